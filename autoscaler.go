@@ -0,0 +1,232 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pivotal-cf/go-metrics-pcf/internal/deltas"
+)
+
+const defaultAutoscalerServiceName = "autoscaler"
+
+// AutoscalerOptions configures an AutoscalerTransporter.
+type AutoscalerOptions struct {
+	// Metrics are the names of the data points to send, such as
+	// "queue.depth" or "requests.rate.1-minute". Characters other than
+	// letters, digits and underscores are replaced with underscores in the
+	// names sent to the autoscaler, so "queue.depth" is sent as
+	// "queue_depth". The scaling policy must use the same names.
+	Metrics []string
+
+	// ServiceName is the name of the autoscaler service in VCAP_SERVICES.
+	// It defaults to "autoscaler".
+	ServiceName string
+
+	// HttpClient overrides the client that is created from the binding.
+	HttpClient HttpClient
+}
+
+// AutoscalerTransporter sends selected data points to the App Autoscaler as
+// custom metrics.
+type AutoscalerTransporter struct {
+	client        HttpClient
+	url           string
+	username      string
+	password      string
+	instanceIndex int
+	metrics       map[string]string
+
+	// sent remembers the time of the last value sent for each metric, since
+	// the autoscaler takes the time a value is received as its timestamp.
+	sent *deltas.Counters
+}
+
+type autoscalerCredentials struct {
+	CustomMetrics struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Url      string `json:"url"`
+		MtlsUrl  string `json:"mtls_url"`
+	} `json:"custom_metrics"`
+}
+
+type autoscalerPayload struct {
+	InstanceIndex int                `json:"instance_index"`
+	Metrics       []autoscalerMetric `json:"metrics"`
+}
+
+type autoscalerMetric struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// NewAutoscalerTransporter returns a transporter for
+// StartExporterWithTransporter that sends metrics to the App Autoscaler the
+// app is bound to. It uses mutual TLS with the instance identity
+// certificate when the binding has an mtls_url, and basic auth otherwise.
+// The app GUID and instance index in opts default to the values in the
+// environment as they do for StartExporter.
+func NewAutoscalerTransporter(autoscalerOptions AutoscalerOptions, opts ...ExporterOption) (*AutoscalerTransporter, error) {
+	if autoscalerOptions.ServiceName == "" {
+		autoscalerOptions.ServiceName = defaultAutoscalerServiceName
+	}
+
+	var creds autoscalerCredentials
+	err := getServiceCredentials(autoscalerOptions.ServiceName, &creds)
+	if err != nil {
+		return nil, err
+	}
+
+	options := InstanceOptions(opts...)
+	if options.AppGuid == "" {
+		return nil, errors.New("could not find the app guid for the autoscaler")
+	}
+
+	transport := &AutoscalerTransporter{
+		client:   autoscalerOptions.HttpClient,
+		metrics:  make(map[string]string),
+		username: creds.CustomMetrics.Username,
+		password: creds.CustomMetrics.Password,
+		sent:     deltas.New(),
+	}
+	transport.instanceIndex, _ = strconv.Atoi(options.InstanceIndex)
+
+	baseUrl := creds.CustomMetrics.Url
+	if creds.CustomMetrics.MtlsUrl != "" {
+		baseUrl = creds.CustomMetrics.MtlsUrl
+		transport.username = ""
+		transport.password = ""
+	}
+	if baseUrl == "" {
+		return nil, errors.New("could not find the custom metrics url in the autoscaler binding")
+	}
+	transport.url = strings.TrimSuffix(baseUrl, "/") + "/v1/apps/" + options.AppGuid + "/metrics"
+
+	if transport.client == nil {
+		transport.client, err = createAutoscalerClient(creds.CustomMetrics.MtlsUrl != "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range autoscalerOptions.Metrics {
+		transport.metrics[name] = autoscalerMetricName(name)
+	}
+
+	return transport, nil
+}
+
+func (t *AutoscalerTransporter) SendMetrics(points []*DataPoint) error {
+	payload := autoscalerPayload{InstanceIndex: t.instanceIndex}
+	sent := t.sent.Batch()
+	for _, point := range SortByTimestamp(points) {
+		name, ok := t.metrics[point.Name]
+		if !ok || math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+			continue
+		}
+
+		if !sent.Current(point.Name, point.Timestamp) {
+			continue
+		}
+
+		payload.Metrics = append(payload.Metrics, autoscalerMetric{
+			Name:  name,
+			Value: point.Value,
+			Unit:  point.Unit,
+		})
+	}
+
+	if len(payload.Metrics) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if t.username != "" {
+		req.SetBasicAuth(t.username, t.password)
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return NewForwarderError(res)
+	}
+
+	sent.Commit()
+
+	return nil
+}
+
+// createAutoscalerClient creates a client that trusts the CF system
+// certificates, and presents the instance identity certificate when mtls is
+// set.
+func createAutoscalerClient(mtls bool) (*http.Client, error) {
+	options := &Options{
+		UseCFSystemCerts: os.Getenv("CF_SYSTEM_CERT_PATH") != "",
+	}
+	if mtls {
+		options.ClientCertFile = os.Getenv("CF_INSTANCE_CERT")
+		options.ClientKeyFile = os.Getenv("CF_INSTANCE_KEY")
+		if options.ClientCertFile == "" || options.ClientKeyFile == "" {
+			return nil, errors.New("CF_INSTANCE_CERT and CF_INSTANCE_KEY are required for the autoscaler mtls_url")
+		}
+	}
+
+	tlsConfig, err := createTLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+		Timeout: defaultRequestTimeout,
+	}, nil
+}
+
+// autoscalerMetricName replaces the characters that custom metric names
+// cannot contain with underscores.
+func autoscalerMetricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,196 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+)
+
+var _ = Describe("App Autoscaler transporter", func() {
+	var (
+		server   *httptest.Server
+		requests chan *http.Request
+		bodies   chan string
+		points   []*pcfmetrics.DataPoint
+	)
+
+	var handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		requests <- req
+		bodies <- string(body)
+	})
+
+	BeforeEach(func() {
+		requests = make(chan *http.Request, 10)
+		bodies = make(chan string, 10)
+		points = []*pcfmetrics.DataPoint{
+			{Name: "queue.depth", Type: "gauge", Value: 12},
+			{Name: "test-timer.duration.mean", Type: "gauge", Unit: "milliseconds", Value: 5.5},
+			{Name: "test-counter", Type: "counter", Value: 6},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.Unsetenv("VCAP_SERVICES")
+		os.Unsetenv("CF_INSTANCE_CERT")
+		os.Unsetenv("CF_INSTANCE_KEY")
+		os.Unsetenv("CF_SYSTEM_CERT_PATH")
+	})
+
+	It("sends the selected metrics with basic auth", func() {
+		server = httptest.NewServer(handler)
+		os.Setenv("VCAP_SERVICES", fmt.Sprintf(`{
+		  "autoscaler": [{
+		    "credentials": {
+		      "custom_metrics": {"username": "user", "password": "pass", "url": "%s"}
+		    }
+		  }]
+		}`, server.URL))
+
+		transport, err := pcfmetrics.NewAutoscalerTransporter(
+			pcfmetrics.AutoscalerOptions{Metrics: []string{"queue.depth", "test-timer.duration.mean"}},
+			pcfmetrics.WithAppGuid("some-app-guid"),
+			pcfmetrics.WithInstanceIndex("2"),
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(transport.SendMetrics(points)).To(Succeed())
+
+		var req *http.Request
+		Eventually(requests).Should(Receive(&req))
+		Expect(req.Method).To(Equal(http.MethodPost))
+		Expect(req.URL.Path).To(Equal("/v1/apps/some-app-guid/metrics"))
+
+		username, password, ok := req.BasicAuth()
+		Expect(ok).To(BeTrue())
+		Expect(username).To(Equal("user"))
+		Expect(password).To(Equal("pass"))
+
+		Eventually(bodies).Should(Receive(MatchJSON(`{
+		  "instance_index": 2,
+		  "metrics": [
+		    {"name": "queue_depth", "value": 12, "unit": ""},
+		    {"name": "test_timer_duration_mean", "value": 5.5, "unit": "milliseconds"}
+		  ]
+		}`)))
+	})
+
+	It("skips values older than the last one sent", func() {
+		server = httptest.NewServer(handler)
+		os.Setenv("VCAP_SERVICES", fmt.Sprintf(`{
+		  "autoscaler": [{
+		    "credentials": {
+		      "custom_metrics": {"username": "user", "password": "pass", "url": "%s"}
+		    }
+		  }]
+		}`, server.URL))
+
+		transport, err := pcfmetrics.NewAutoscalerTransporter(
+			pcfmetrics.AutoscalerOptions{Metrics: []string{"queue.depth", "test-timer.duration.mean"}},
+			pcfmetrics.WithAppGuid("some-app-guid"),
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "queue.depth", Type: "gauge", Value: 12, Timestamp: 2000},
+		})).To(Succeed())
+		Eventually(bodies).Should(Receive())
+
+		Expect(transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "queue.depth", Type: "gauge", Value: 3, Timestamp: 1000},
+			{Name: "test-timer.duration.mean", Type: "gauge", Unit: "milliseconds", Value: 5.5, Timestamp: 1000},
+		})).To(Succeed())
+		Eventually(bodies).Should(Receive(MatchJSON(`{
+		  "instance_index": 0,
+		  "metrics": [
+		    {"name": "test_timer_duration_mean", "value": 5.5, "unit": "milliseconds"}
+		  ]
+		}`)))
+	})
+
+	It("uses the instance identity certificate for the mtls_url", func() {
+		certPEM, keyPEM := generateServerCertificate()
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		Expect(err).ToNot(HaveOccurred())
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(certPEM)
+
+		server = httptest.NewUnstartedServer(handler)
+		server.TLS = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool,
+		}
+		server.StartTLS()
+
+		dir, err := ioutil.TempDir("", "autoscaler")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		Expect(os.Mkdir(filepath.Join(dir, "certs"), 0700)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "certs", "ca.pem"), certPEM, 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600)).To(Succeed())
+
+		os.Setenv("CF_SYSTEM_CERT_PATH", filepath.Join(dir, "certs"))
+		os.Setenv("CF_INSTANCE_CERT", filepath.Join(dir, "cert.pem"))
+		os.Setenv("CF_INSTANCE_KEY", filepath.Join(dir, "key.pem"))
+		os.Setenv("VCAP_SERVICES", fmt.Sprintf(`{
+		  "autoscaler": [{
+		    "credentials": {
+		      "custom_metrics": {"url": "https://unused.example.com", "mtls_url": "%s"}
+		    }
+		  }]
+		}`, server.URL))
+
+		transport, err := pcfmetrics.NewAutoscalerTransporter(
+			pcfmetrics.AutoscalerOptions{Metrics: []string{"queue.depth"}},
+			pcfmetrics.WithAppGuid("some-app-guid"),
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(transport.SendMetrics(points)).To(Succeed())
+
+		var req *http.Request
+		Eventually(requests).Should(Receive(&req))
+		Expect(req.URL.Path).To(Equal("/v1/apps/some-app-guid/metrics"))
+		Expect(req.TLS.PeerCertificates).To(HaveLen(1))
+		_, _, ok := req.BasicAuth()
+		Expect(ok).To(BeFalse())
+	})
+
+	It("returns an error when the app is not bound to the autoscaler", func() {
+		server = httptest.NewServer(handler)
+		os.Setenv("VCAP_SERVICES", `{}`)
+
+		_, err := pcfmetrics.NewAutoscalerTransporter(
+			pcfmetrics.AutoscalerOptions{Metrics: []string{"queue.depth"}},
+			pcfmetrics.WithAppGuid("some-app-guid"),
+		)
+		Expect(err).To(MatchError("could not find service with name: autoscaler"))
+	})
+})
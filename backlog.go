@@ -0,0 +1,146 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	backlogDepthMetricName   = "pcfmetrics.backlog.depth"
+	backlogDroppedMetricName = "pcfmetrics.backlog.dropped"
+)
+
+// batchBacklog is a ring buffer of the most recent batches that could not be
+// sent. When it is full the oldest batch is dropped to make room.
+type batchBacklog struct {
+	mu      sync.Mutex
+	batches [][]*DataPoint
+	start   int
+	size    int
+	dropped metrics.Counter
+}
+
+func newBatchBacklog(capacity int) *batchBacklog {
+	return &batchBacklog{
+		batches: make([][]*DataPoint, capacity),
+		dropped: metrics.NewCounter(),
+	}
+}
+
+// register exposes the depth of the backlog and the number of dropped
+// batches in the registry so they are exported with everything else.
+func (b *batchBacklog) register(registry metrics.Registry) {
+	registry.Unregister(backlogDepthMetricName)
+	registry.Unregister(backlogDroppedMetricName)
+	registry.Register(backlogDepthMetricName, metrics.NewFunctionalGauge(b.depth))
+	registry.Register(backlogDroppedMetricName, b.dropped)
+}
+
+func (b *batchBacklog) depth() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return int64(b.size)
+}
+
+// push adds a batch as the newest one. Empty batches are ignored.
+func (b *batchBacklog) push(points []*DataPoint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(points) == 0 {
+		return
+	}
+
+	if b.size == len(b.batches) {
+		b.batches[b.start] = nil
+		b.start = (b.start + 1) % len(b.batches)
+		b.size--
+		b.dropped.Inc(1)
+	}
+
+	b.batches[(b.start+b.size)%len(b.batches)] = points
+	b.size++
+}
+
+// oldest returns the oldest batch without removing it.
+func (b *batchBacklog) oldest() ([]*DataPoint, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.size == 0 {
+		return nil, false
+	}
+
+	return b.batches[b.start], true
+}
+
+// replaceOldest replaces the oldest batch with the points of it that are
+// still to be sent, or removes it when there are none.
+func (b *batchBacklog) replaceOldest(points []*DataPoint) {
+	if len(points) == 0 {
+		b.pop()
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.size == 0 {
+		return
+	}
+
+	b.batches[b.start] = points
+}
+
+func (b *batchBacklog) pop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.size == 0 {
+		return
+	}
+
+	b.batches[b.start] = nil
+	b.start = (b.start + 1) % len(b.batches)
+	b.size--
+}
+
+// all returns every batch, oldest first, merged into one.
+func (b *batchBacklog) all() []*DataPoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var points []*DataPoint
+	for i := 0; i < b.size; i++ {
+		points = append(points, b.batches[(b.start+i)%len(b.batches)]...)
+	}
+
+	return points
+}
+
+func (b *batchBacklog) clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := range b.batches {
+		b.batches[i] = nil
+	}
+	b.start = 0
+	b.size = 0
+}
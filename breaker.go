@@ -0,0 +1,154 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// CircuitState is the state of the circuit breaker around the transport.
+type CircuitState int
+
+const (
+	// CircuitClosed sends every batch.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen drops every batch until the open timeout has passed.
+	CircuitOpen
+	// CircuitHalfOpen sends batches to probe whether the forwarder has
+	// recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrCircuitOpen is returned instead of sending while the circuit breaker is
+// open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+const defaultCircuitOpenIntervals = 5
+
+// pointSpooler is implemented by transports that can keep the batches the
+// circuit breaker rejects, so they are sent once the breaker closes.
+type pointSpooler interface {
+	spoolPoints(points []*DataPoint)
+}
+
+type circuitBreaker struct {
+	transport         Transporter
+	failureThreshold  int
+	openTimeout       time.Duration
+	halfOpenSuccesses int
+	onStateChange     func(from, to CircuitState)
+
+	state     CircuitState
+	failures  int
+	successes int
+	openedAt  time.Time
+}
+
+func newCircuitBreaker(transport Transporter, options *Options) *circuitBreaker {
+	b := &circuitBreaker{
+		transport:         transport,
+		failureThreshold:  options.CircuitFailureThreshold,
+		openTimeout:       options.CircuitOpenTimeout,
+		halfOpenSuccesses: options.CircuitHalfOpenSuccesses,
+		onStateChange:     options.OnCircuitStateChange,
+	}
+
+	if b.openTimeout == time.Duration(0) {
+		b.openTimeout = defaultCircuitOpenIntervals * options.Frequency
+	}
+
+	if b.halfOpenSuccesses < 1 {
+		b.halfOpenSuccesses = 1
+	}
+
+	if b.onStateChange == nil {
+		b.onStateChange = logCircuitStateChange
+	}
+
+	return b
+}
+
+func (b *circuitBreaker) SendMetrics(points []*DataPoint) error {
+	if b.state == CircuitOpen {
+		if time.Since(b.openedAt) < b.openTimeout {
+			if spooler, ok := b.transport.(pointSpooler); ok {
+				spooler.spoolPoints(points)
+			}
+			return ErrCircuitOpen
+		}
+
+		b.setState(CircuitHalfOpen)
+	}
+
+	err := b.transport.SendMetrics(points)
+	if err != nil {
+		b.recordFailure()
+		return err
+	}
+
+	b.recordSuccess()
+	return nil
+}
+
+func (b *circuitBreaker) recordFailure() {
+	b.successes = 0
+	b.failures++
+
+	if b.state == CircuitHalfOpen || b.failures >= b.failureThreshold {
+		b.openedAt = time.Now()
+		b.setState(CircuitOpen)
+	}
+}
+
+func (b *circuitBreaker) recordSuccess() {
+	b.failures = 0
+
+	if b.state == CircuitHalfOpen {
+		b.successes++
+		if b.successes >= b.halfOpenSuccesses {
+			b.successes = 0
+			b.setState(CircuitClosed)
+		}
+	}
+}
+
+func (b *circuitBreaker) setState(state CircuitState) {
+	if state == b.state {
+		return
+	}
+
+	from := b.state
+	b.state = state
+	b.onStateChange(from, state)
+}
+
+func logCircuitStateChange(from, to CircuitState) {
+	log.Printf("Metrics export circuit breaker changed from %s to %s", from, to)
+}
@@ -0,0 +1,92 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"sync"
+)
+
+const (
+	// CompressionGzip compresses request bodies with gzip.
+	CompressionGzip = "gzip"
+	// CompressionZstd compresses request bodies with zstd. It is only
+	// available once github.com/pivotal-cf/go-metrics-pcf/zstd is imported.
+	CompressionZstd = "zstd"
+
+	defaultCompressionThreshold = 1024
+)
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]func([]byte) ([]byte, error){
+		CompressionGzip: compressGzip,
+	}
+)
+
+// RegisterCompression makes a content encoding available to WithCompression.
+// It is meant to be called from the init function of a package that
+// implements the encoding, such as github.com/pivotal-cf/go-metrics-pcf/zstd.
+func RegisterCompression(encoding string, compress func([]byte) ([]byte, error)) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+
+	compressors[encoding] = compress
+}
+
+// Compress encodes body with the given content encoding, for transporters
+// that compress their requests.
+func Compress(encoding string, body []byte) ([]byte, error) {
+	compress, err := lookupCompression(encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	return compress(body)
+}
+
+func lookupCompression(encoding string) (func([]byte) ([]byte, error), error) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	compress, ok := compressors[encoding]
+	if !ok && encoding == CompressionZstd {
+		return nil, fmt.Errorf("unsupported compression: %s: import github.com/pivotal-cf/go-metrics-pcf/zstd to enable it", encoding)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unsupported compression: %s", encoding)
+	}
+
+	return compress, nil
+}
+
+func compressGzip(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// reconnectingConn is a connection that is dialed on first use and dialed
+// again after a write fails, so that a restarted agent does not stop the
+// exporter. The connection uses TLS when tlsConfig is set.
+type reconnectingConn struct {
+	network   string
+	address   string
+	timeout   time.Duration
+	tlsConfig *tls.Config
+	conn      net.Conn
+}
+
+func (c *reconnectingConn) Write(b []byte) (int, error) {
+	if c.conn == nil {
+		conn, err := c.dial()
+		if err != nil {
+			return 0, err
+		}
+		c.conn = conn
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+
+	n, err := c.conn.Write(b)
+	if err != nil {
+		c.Close()
+	}
+
+	return n, err
+}
+
+func (c *reconnectingConn) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: c.timeout}
+	if c.tlsConfig != nil {
+		return tls.DialWithDialer(dialer, c.network, c.address, c.tlsConfig)
+	}
+
+	return dialer.Dial(c.network, c.address)
+}
+
+func (c *reconnectingConn) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
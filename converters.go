@@ -67,16 +67,16 @@ type timer interface {
 	Percentiles([]float64) []float64
 }
 
-func convertGauge(gauge gauge, name string) *dataPoint {
+func convertGauge(gauge gauge, name string) *DataPoint {
 	return convertGenericGauge(float64(gauge.Value()), name)
 }
 
-func convertGaugeFloat64(gauge gaugeFloat64, name string) *dataPoint {
+func convertGaugeFloat64(gauge gaugeFloat64, name string) *DataPoint {
 	return convertGenericGauge(gauge.Value(), name)
 }
 
-func convertMeter(meter meter, name string) []*dataPoint {
-	return []*dataPoint{
+func convertMeter(meter meter, name string) []*DataPoint {
+	return []*DataPoint{
 		convertCounter(meter, joinNameParts(name, "count")),
 		convertGenericGauge(meter.Rate1(), joinNameParts(name, "rate.1-minute")),
 		convertGenericGauge(meter.Rate5(), joinNameParts(name, "rate.5-minute")),
@@ -85,12 +85,12 @@ func convertMeter(meter meter, name string) []*dataPoint {
 	}
 }
 
-func convertHistogram(histogram histogram, name string) []*dataPoint {
+func convertHistogram(histogram histogram, name string) []*DataPoint {
 	return convertHistogramWithTimeUnit(histogram, name, time.Duration(0))
 }
 
-func convertHistogramWithTimeUnit(histogram histogram, name string, timeUnit time.Duration) []*dataPoint {
-	points := []*dataPoint{
+func convertHistogramWithTimeUnit(histogram histogram, name string, timeUnit time.Duration) []*DataPoint {
+	points := []*DataPoint{
 		convertCounter(histogram, joinNameParts(name, "count")),
 		convertGenericGaugeWithUnit(histogram.Mean(), joinNameParts(name, "mean"), timeUnit),
 		convertGenericGaugeWithUnit(histogram.StdDev(), joinNameParts(name, "stddev"), timeUnit),
@@ -105,9 +105,10 @@ func convertHistogramWithTimeUnit(histogram histogram, name string, timeUnit tim
 	return points
 }
 
-func generatePercentileDataPoints(histogram histogram, name string, timeUnit time.Duration) []*dataPoint {
-	var points []*dataPoint
-	percentileIds := []float64{75, 95, 98, 99, 99.9}
+var percentileIds = []float64{75, 95, 98, 99, 99.9}
+
+func generatePercentileDataPoints(histogram histogram, name string, timeUnit time.Duration) []*DataPoint {
+	var points []*DataPoint
 	for i, value := range histogram.Percentiles(percentileIds) {
 		dataPoint := convertGenericGaugeWithUnit(
 			float64(value),
@@ -126,8 +127,8 @@ func getPercentileName(name string, percentileId float64) string {
 	return joinNameParts(name, fmt.Sprintf("%sthPercentile", percentileWithoutPeriods))
 }
 
-func convertTimer(timer timer, name string, timeUnit time.Duration) []*dataPoint {
-	points := []*dataPoint{
+func convertTimer(timer timer, name string, timeUnit time.Duration) []*DataPoint {
+	points := []*DataPoint{
 		convertCounter(timer, joinNameParts(name, "count")),
 	}
 
@@ -146,28 +147,28 @@ func convertTimer(timer timer, name string, timeUnit time.Duration) []*dataPoint
 	return points
 }
 
-func convertCounter(counter counter, name string) *dataPoint {
-	return &dataPoint{
+func convertCounter(counter counter, name string) *DataPoint {
+	return &DataPoint{
 		Name:      name,
 		Value:     float64(counter.Count()),
 		Type:      "counter",
 	}
 }
 
-func convertGenericGauge(value float64, name string) *dataPoint {
-	return &dataPoint{
+func convertGenericGauge(value float64, name string) *DataPoint {
+	return &DataPoint{
 		Name:      name,
 		Value:     value,
 		Type:      "gauge",
 	}
 }
 
-func convertGenericGaugeWithUnit(value float64, name string, timeUnit time.Duration) *dataPoint {
+func convertGenericGaugeWithUnit(value float64, name string, timeUnit time.Duration) *DataPoint {
 	if timeUnit == time.Duration(0) {
 		return convertGenericGauge(value, name)
 	}
 
-	return &dataPoint{
+	return &DataPoint{
 		Name:      name,
 		Value:     value / float64(timeUnit),
 		Type:      "gauge",
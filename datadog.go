@@ -0,0 +1,310 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pivotal-cf/go-metrics-pcf/internal/deltas"
+)
+
+const defaultDatadogSite = "datadoghq.com"
+
+// Metric types in the v2 series API.
+const (
+	datadogCountType = 1
+	datadogGaugeType = 3
+)
+
+// DatadogOptions configures a DatadogTransporter.
+type DatadogOptions struct {
+	// APIKey is sent in the DD-API-KEY header.
+	APIKey string
+
+	// Site is the Datadog site, such as "datadoghq.eu". It defaults to
+	// "datadoghq.com".
+	Site string
+
+	// Url overrides the series endpoint derived from Site and APIVersion,
+	// for example to send metrics through a proxy.
+	Url string
+
+	// APIVersion is 1 or 2. It defaults to 2.
+	APIVersion int
+
+	// Prefix is prepended to every metric name.
+	Prefix string
+
+	// Tags are added to every series, after the app_id and instance_index
+	// tags.
+	Tags map[string]string
+
+	// Host is the host of every series. Datadog does not set one when it is
+	// empty.
+	Host string
+
+	// HttpClient defaults to a client with a 30 second timeout.
+	HttpClient HttpClient
+}
+
+// DatadogTransporter sends data points to the Datadog series API. Gauges
+// are sent as gauges, and counters as counts of the change since the last
+// successful send.
+type DatadogTransporter struct {
+	options  DatadogOptions
+	client   HttpClient
+	url      string
+	tags     []string
+	interval int64
+	counters *deltas.Counters
+}
+
+type datadogSeriesV1 struct {
+	Metric   string       `json:"metric"`
+	Type     string       `json:"type"`
+	Points   [][2]float64 `json:"points"`
+	Interval int64        `json:"interval,omitempty"`
+	Host     string       `json:"host,omitempty"`
+	Tags     []string     `json:"tags,omitempty"`
+}
+
+type datadogSeriesV2 struct {
+	Metric    string              `json:"metric"`
+	Type      int                 `json:"type"`
+	Points    []datadogPointV2    `json:"points"`
+	Interval  int64               `json:"interval,omitempty"`
+	Unit      string              `json:"unit,omitempty"`
+	Resources []datadogResourceV2 `json:"resources,omitempty"`
+	Tags      []string            `json:"tags,omitempty"`
+}
+
+type datadogPointV2 struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type datadogResourceV2 struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// NewDatadogTransporter returns a transporter for
+// StartExporterWithTransporter that sends metrics to Datadog. Pass it the
+// same options as the exporter: the frequency is the interval of the counts,
+// and the app GUID and instance index are the app_id and instance_index
+// tags, which default to the values in the environment as they do for
+// StartExporter.
+func NewDatadogTransporter(datadogOptions DatadogOptions, opts ...ExporterOption) *DatadogTransporter {
+	if datadogOptions.Site == "" {
+		datadogOptions.Site = defaultDatadogSite
+	}
+	if datadogOptions.APIVersion == 0 {
+		datadogOptions.APIVersion = 2
+	}
+
+	url := datadogOptions.Url
+	if url == "" {
+		url = "https://api." + datadogOptions.Site + "/api/v1/series"
+		if datadogOptions.APIVersion == 2 {
+			url = "https://api." + datadogOptions.Site + "/api/v2/series"
+		}
+	}
+
+	client := datadogOptions.HttpClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultRequestTimeout}
+	}
+
+	options := InstanceOptions(opts...)
+
+	return &DatadogTransporter{
+		options:  datadogOptions,
+		client:   client,
+		url:      url,
+		tags:     datadogTags(options, datadogOptions.Tags),
+		interval: int64(options.Frequency.Seconds()),
+		counters: deltas.New(),
+	}
+}
+
+func (t *DatadogTransporter) SendMetrics(points []*DataPoint) error {
+	var finite []*DataPoint
+	for _, point := range SortByTimestamp(points) {
+		if !math.IsNaN(point.Value) && !math.IsInf(point.Value, 0) {
+			finite = append(finite, point)
+		}
+	}
+
+	if len(finite) == 0 {
+		return nil
+	}
+
+	counters := t.counters.Batch()
+
+	var payload interface{}
+	if t.options.APIVersion == 1 {
+		payload = map[string]interface{}{"series": t.seriesV1(finite, counters)}
+	} else {
+		payload = map[string]interface{}{"series": t.seriesV2(finite, counters)}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", t.options.APIKey)
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return NewForwarderError(res)
+	}
+
+	counters.Commit()
+
+	return nil
+}
+
+func (t *DatadogTransporter) seriesV1(points []*DataPoint, counters *deltas.Batch) []datadogSeriesV1 {
+	var series []datadogSeriesV1
+	for _, point := range points {
+		s := datadogSeriesV1{
+			Metric: datadogMetricName(t.options.Prefix, point.Name),
+			Type:   "gauge",
+			Points: [][2]float64{{float64(point.Timestamp / 1000), point.Value}},
+			Host:   t.options.Host,
+			Tags:   t.tags,
+		}
+
+		if point.Type == "counter" {
+			delta, ok := counters.Delta(point.Name, point.Value, point.Timestamp)
+			if !ok {
+				continue
+			}
+
+			s.Type = "count"
+			s.Points[0][1] = delta
+			s.Interval = t.interval
+		}
+
+		series = append(series, s)
+	}
+
+	return series
+}
+
+func (t *DatadogTransporter) seriesV2(points []*DataPoint, counters *deltas.Batch) []datadogSeriesV2 {
+	var series []datadogSeriesV2
+	for _, point := range points {
+		s := datadogSeriesV2{
+			Metric: datadogMetricName(t.options.Prefix, point.Name),
+			Type:   datadogGaugeType,
+			Points: []datadogPointV2{{Timestamp: point.Timestamp / 1000, Value: point.Value}},
+			Unit:   datadogUnit(point.Unit),
+			Tags:   t.tags,
+		}
+
+		if t.options.Host != "" {
+			s.Resources = []datadogResourceV2{{Name: t.options.Host, Type: "host"}}
+		}
+
+		if point.Type == "counter" {
+			delta, ok := counters.Delta(point.Name, point.Value, point.Timestamp)
+			if !ok {
+				continue
+			}
+
+			s.Type = datadogCountType
+			s.Points[0].Value = delta
+			s.Interval = t.interval
+		}
+
+		series = append(series, s)
+	}
+
+	return series
+}
+
+// datadogTags returns the app_id and instance_index tags followed by the
+// global tags, sorted by key.
+func datadogTags(options *Options, global map[string]string) []string {
+	var tags []string
+	if options.AppGuid != "" {
+		tags = append(tags, "app_id:"+options.AppGuid)
+	}
+	if options.InstanceIndex != "" {
+		tags = append(tags, "instance_index:"+options.InstanceIndex)
+	}
+
+	keys := make([]string, 0, len(global))
+	for key := range global {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		tags = append(tags, key+":"+global[key])
+	}
+
+	return tags
+}
+
+// datadogMetricName replaces the characters that Datadog does not allow in
+// metric names with underscores.
+func datadogMetricName(prefix, name string) string {
+	if prefix != "" {
+		name = joinNameParts(prefix, name)
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '.' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// datadogUnit converts the time unit names of the data points to Datadog
+// units.
+func datadogUnit(unit string) string {
+	switch unit {
+	case "seconds":
+		return "second"
+	case "milliseconds":
+		return "millisecond"
+	case "microseconds":
+		return "microsecond"
+	case "nanoseconds":
+		return "nanosecond"
+	default:
+		return unit
+	}
+}
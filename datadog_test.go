@@ -0,0 +1,151 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+)
+
+var _ = Describe("Datadog transporter", func() {
+	var (
+		server     *httptest.Server
+		requests   chan *http.Request
+		bodies     chan string
+		statusCode int
+		points     []*pcfmetrics.DataPoint
+	)
+
+	BeforeEach(func() {
+		requests = make(chan *http.Request, 10)
+		bodies = make(chan string, 10)
+		statusCode = http.StatusAccepted
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			requests <- req
+			bodies <- string(body)
+			w.WriteHeader(statusCode)
+		}))
+
+		points = []*pcfmetrics.DataPoint{
+			{Name: "test-counter", Type: "counter", Value: 6, Timestamp: 1500000000123},
+			{Name: "test-timer.duration.mean", Type: "gauge", Unit: "milliseconds", Value: 5.5, Timestamp: 1500000000123},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("sends counts and gauges to the v2 series API", func() {
+		transport := pcfmetrics.NewDatadogTransporter(
+			pcfmetrics.DatadogOptions{
+				APIKey: "some-api-key",
+				Url:    server.URL + "/api/v2/series",
+				Tags:   map[string]string{"env": "staging"},
+			},
+			pcfmetrics.WithAppGuid("some-app-guid"),
+			pcfmetrics.WithInstanceIndex("1"),
+			pcfmetrics.WithFrequency(10*time.Second),
+		)
+
+		Expect(transport.SendMetrics(points)).To(Succeed())
+
+		var req *http.Request
+		Eventually(requests).Should(Receive(&req))
+		Expect(req.URL.Path).To(Equal("/api/v2/series"))
+		Expect(req.Header.Get("DD-API-KEY")).To(Equal("some-api-key"))
+		Expect(req.Header.Get("Content-Type")).To(Equal("application/json"))
+
+		Eventually(bodies).Should(Receive(MatchJSON(`{"series": [
+		  {
+		    "metric": "test_counter",
+		    "type": 1,
+		    "points": [{"timestamp": 1500000000, "value": 6}],
+		    "interval": 10,
+		    "tags": ["app_id:some-app-guid", "instance_index:1", "env:staging"]
+		  },
+		  {
+		    "metric": "test_timer.duration.mean",
+		    "type": 3,
+		    "points": [{"timestamp": 1500000000, "value": 5.5}],
+		    "unit": "millisecond",
+		    "tags": ["app_id:some-app-guid", "instance_index:1", "env:staging"]
+		  }
+		]}`)))
+
+		points[0].Value = 10
+		Expect(transport.SendMetrics(points[:1])).To(Succeed())
+		Eventually(bodies).Should(Receive(ContainSubstring(`"points":[{"timestamp":1500000000,"value":4}]`)))
+	})
+
+	It("sends to the v1 series API", func() {
+		transport := pcfmetrics.NewDatadogTransporter(
+			pcfmetrics.DatadogOptions{
+				APIKey:     "some-api-key",
+				Url:        server.URL + "/api/v1/series",
+				APIVersion: 1,
+				Host:       "some-host",
+			},
+			pcfmetrics.WithAppGuid("some-app-guid"),
+			pcfmetrics.WithInstanceIndex("1"),
+		)
+
+		Expect(transport.SendMetrics(points)).To(Succeed())
+
+		Eventually(bodies).Should(Receive(MatchJSON(`{"series": [
+		  {
+		    "metric": "test_counter",
+		    "type": "count",
+		    "points": [[1500000000, 6]],
+		    "interval": 60,
+		    "host": "some-host",
+		    "tags": ["app_id:some-app-guid", "instance_index:1"]
+		  },
+		  {
+		    "metric": "test_timer.duration.mean",
+		    "type": "gauge",
+		    "points": [[1500000000, 5.5]],
+		    "host": "some-host",
+		    "tags": ["app_id:some-app-guid", "instance_index:1"]
+		  }
+		]}`)))
+	})
+
+	It("keeps the counter values when Datadog rejects the request", func() {
+		statusCode = http.StatusForbidden
+
+		transport := pcfmetrics.NewDatadogTransporter(pcfmetrics.DatadogOptions{Url: server.URL}, pcfmetrics.WithAppGuid("some-app-guid"))
+
+		err := transport.SendMetrics(points[:1])
+		var authErr *pcfmetrics.AuthenticationError
+		Expect(errors.As(err, &authErr)).To(BeTrue())
+
+		statusCode = http.StatusAccepted
+		Expect(transport.SendMetrics(points[:1])).To(Succeed())
+
+		Eventually(bodies).Should(Receive())
+		Eventually(bodies).Should(Receive(ContainSubstring(`"value":6`)))
+	})
+})
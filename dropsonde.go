@@ -0,0 +1,216 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pivotal-cf/go-metrics-pcf/internal/deltas"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	defaultDropsondeAddress      = "localhost:3457"
+	defaultDropsondeOrigin       = "go-metrics-pcf"
+	defaultDropsondeWriteTimeout = 5 * time.Second
+)
+
+// Dropsonde event types and field numbers from events/envelope.proto and
+// events/metric.proto.
+const (
+	dropsondeValueMetricType  = 6
+	dropsondeCounterEventType = 7
+
+	dropsondeOriginField       = 1
+	dropsondeEventTypeField    = 2
+	dropsondeTimestampField    = 6
+	dropsondeValueMetricField  = 9
+	dropsondeCounterEventField = 10
+	dropsondeDeploymentField   = 13
+	dropsondeIndexField        = 15
+	dropsondeTagsField         = 17
+)
+
+// DropsondeOptions configures a DropsondeTransporter.
+type DropsondeOptions struct {
+	// Address is the host and port where metron listens for dropsonde
+	// envelopes. It defaults to localhost:3457.
+	Address string
+
+	// Origin defaults to the application name in VCAP_APPLICATION.
+	Origin string
+
+	// Deployment defaults to the space name in VCAP_APPLICATION.
+	Deployment string
+
+	// Index defaults to INSTANCE_INDEX.
+	Index string
+
+	// Tags are added to every envelope.
+	Tags map[string]string
+}
+
+// DropsondeTransporter sends data points to metron as dropsonde v1
+// envelopes over UDP, one envelope per datagram. Gauges are sent as
+// ValueMetric events and counters as CounterEvent events with the change
+// since the last send.
+type DropsondeTransporter struct {
+	options  DropsondeOptions
+	conn     *reconnectingConn
+	counters *deltas.Counters
+}
+
+// NewDropsondeTransporter returns a transporter for
+// StartExporterWithTransporter that sends metrics to metron.
+func NewDropsondeTransporter(options DropsondeOptions) *DropsondeTransporter {
+	if options.Address == "" {
+		options.Address = defaultDropsondeAddress
+	}
+	if options.Origin == "" {
+		options.Origin, _ = getApplicationName()
+	}
+	if options.Origin == "" {
+		options.Origin = defaultDropsondeOrigin
+	}
+	if options.Deployment == "" {
+		options.Deployment, _ = getSpaceName()
+	}
+	if options.Index == "" {
+		options.Index = getInstanceIndex()
+	}
+
+	return &DropsondeTransporter{
+		options: options,
+		conn: &reconnectingConn{
+			network: "udp",
+			address: options.Address,
+			timeout: defaultDropsondeWriteTimeout,
+		},
+		counters: deltas.New(),
+	}
+}
+
+func (t *DropsondeTransporter) SendMetrics(points []*DataPoint) error {
+	counters := t.counters.Batch()
+	for _, point := range SortByTimestamp(points) {
+		if math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+			continue
+		}
+
+		var envelope []byte
+		if point.Type == "counter" {
+			delta, ok := counters.Delta(point.Name, point.Value, point.Timestamp)
+			if !ok {
+				continue
+			}
+			envelope = t.encodeEnvelope(point, dropsondeCounterEventType, dropsondeCounterEventField, encodeCounterEvent(point, delta))
+		} else {
+			envelope = t.encodeEnvelope(point, dropsondeValueMetricType, dropsondeValueMetricField, encodeValueMetric(point))
+		}
+
+		_, err := t.conn.Write(envelope)
+		if err != nil {
+			return err
+		}
+
+		if point.Type == "counter" {
+			t.counters.Update(point.Name, point.Value, point.Timestamp)
+		}
+	}
+
+	return nil
+}
+
+// Close closes the connection to metron.
+func (t *DropsondeTransporter) Close() error {
+	return t.conn.Close()
+}
+
+func (t *DropsondeTransporter) encodeEnvelope(point *DataPoint, eventType protowire.Number, eventField protowire.Number, event []byte) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, dropsondeOriginField, protowire.BytesType)
+	b = protowire.AppendString(b, t.options.Origin)
+	b = protowire.AppendTag(b, dropsondeEventTypeField, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(eventType))
+	b = protowire.AppendTag(b, dropsondeTimestampField, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(point.Timestamp*int64(time.Millisecond)))
+	b = protowire.AppendTag(b, eventField, protowire.BytesType)
+	b = protowire.AppendBytes(b, event)
+
+	if t.options.Deployment != "" {
+		b = protowire.AppendTag(b, dropsondeDeploymentField, protowire.BytesType)
+		b = protowire.AppendString(b, t.options.Deployment)
+	}
+	if t.options.Index != "" {
+		b = protowire.AppendTag(b, dropsondeIndexField, protowire.BytesType)
+		b = protowire.AppendString(b, t.options.Index)
+	}
+
+	keys := make([]string, 0, len(t.options.Tags))
+	for key := range t.options.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, key)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, t.options.Tags[key])
+
+		b = protowire.AppendTag(b, dropsondeTagsField, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+
+	return b
+}
+
+// encodeValueMetric encodes a ValueMetric with its name, value and unit.
+func encodeValueMetric(point *DataPoint) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, point.Name)
+	b = protowire.AppendTag(b, 2, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(point.Value))
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendString(b, point.Unit)
+	return b
+}
+
+// encodeCounterEvent encodes a CounterEvent with its name, delta and total.
+func encodeCounterEvent(point *DataPoint, delta float64) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, point.Name)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, dropsondeCounterValue(delta))
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	b = protowire.AppendVarint(b, dropsondeCounterValue(point.Value))
+	return b
+}
+
+// dropsondeCounterValue converts a counter value to the unsigned value of a
+// counter event. Counters that went down are sent as 0 rather than wrapping
+// around.
+func dropsondeCounterValue(value float64) uint64 {
+	if value < 0 {
+		return 0
+	}
+
+	return uint64(value)
+}
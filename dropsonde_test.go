@@ -0,0 +1,169 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics_test
+
+import (
+	"math"
+	"net"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+)
+
+var _ = Describe("Dropsonde transporter", func() {
+	var (
+		packetConn net.PacketConn
+		envelopes  chan map[protowire.Number][]interface{}
+	)
+
+	BeforeEach(func() {
+		var err error
+		packetConn, err = net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		envelopes = make(chan map[protowire.Number][]interface{}, 100)
+		go func() {
+			buf := make([]byte, 65536)
+			for {
+				n, _, err := packetConn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				envelopes <- decodeProtobuf(buf[:n])
+			}
+		}()
+
+		os.Setenv("VCAP_APPLICATION", `{"application_name": "my-app", "space_name": "my-space"}`)
+		os.Setenv("INSTANCE_INDEX", "2")
+	})
+
+	AfterEach(func() {
+		packetConn.Close()
+		os.Unsetenv("VCAP_APPLICATION")
+		os.Unsetenv("INSTANCE_INDEX")
+	})
+
+	It("sends gauges as value metrics with the origin, deployment and index from the environment", func() {
+		transport := pcfmetrics.NewDropsondeTransporter(pcfmetrics.DropsondeOptions{
+			Address: packetConn.LocalAddr().String(),
+			Tags:    map[string]string{"source": "go-metrics"},
+		})
+		defer transport.Close()
+
+		err := transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-timer.duration.mean", Type: "gauge", Unit: "milliseconds", Value: 5.5, Timestamp: 1500000000123},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var envelope map[protowire.Number][]interface{}
+		Eventually(envelopes).Should(Receive(&envelope))
+		Expect(envelope[1]).To(Equal([]interface{}{"my-app"}))
+		Expect(envelope[2]).To(Equal([]interface{}{uint64(6)}))
+		Expect(envelope[6]).To(Equal([]interface{}{uint64(1500000000123000000)}))
+		Expect(envelope[13]).To(Equal([]interface{}{"my-space"}))
+		Expect(envelope[15]).To(Equal([]interface{}{"2"}))
+
+		tag := decodeProtobuf([]byte(envelope[17][0].(string)))
+		Expect(tag[1]).To(Equal([]interface{}{"source"}))
+		Expect(tag[2]).To(Equal([]interface{}{"go-metrics"}))
+
+		valueMetric := decodeProtobuf([]byte(envelope[9][0].(string)))
+		Expect(valueMetric[1]).To(Equal([]interface{}{"test-timer.duration.mean"}))
+		Expect(valueMetric[2]).To(Equal([]interface{}{math.Float64bits(5.5)}))
+		Expect(valueMetric[3]).To(Equal([]interface{}{"milliseconds"}))
+	})
+
+	It("sends counters as counter events with their deltas", func() {
+		transport := pcfmetrics.NewDropsondeTransporter(pcfmetrics.DropsondeOptions{
+			Address: packetConn.LocalAddr().String(),
+			Origin:  "my-origin",
+		})
+		defer transport.Close()
+
+		for _, value := range []float64{6, 10} {
+			err := transport.SendMetrics([]*pcfmetrics.DataPoint{{Name: "test-counter", Type: "counter", Value: value}})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		var first, second map[protowire.Number][]interface{}
+		Eventually(envelopes).Should(Receive(&first))
+		Eventually(envelopes).Should(Receive(&second))
+
+		Expect(first[1]).To(Equal([]interface{}{"my-origin"}))
+		Expect(first[2]).To(Equal([]interface{}{uint64(7)}))
+
+		counterEvent := decodeProtobuf([]byte(second[10][0].(string)))
+		Expect(counterEvent[1]).To(Equal([]interface{}{"test-counter"}))
+		Expect(counterEvent[2]).To(Equal([]interface{}{uint64(4)}))
+		Expect(counterEvent[3]).To(Equal([]interface{}{uint64(10)}))
+	})
+
+	It("sends counters that went down as 0", func() {
+		transport := pcfmetrics.NewDropsondeTransporter(pcfmetrics.DropsondeOptions{
+			Address: packetConn.LocalAddr().String(),
+		})
+		defer transport.Close()
+
+		for _, value := range []float64{6, -2} {
+			err := transport.SendMetrics([]*pcfmetrics.DataPoint{{Name: "test-counter", Type: "counter", Value: value}})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		var first, second map[protowire.Number][]interface{}
+		Eventually(envelopes).Should(Receive(&first))
+		Eventually(envelopes).Should(Receive(&second))
+
+		counterEvent := decodeProtobuf([]byte(second[10][0].(string)))
+		Expect(counterEvent[2]).To(Equal([]interface{}{uint64(0)}))
+		Expect(counterEvent[3]).To(Equal([]interface{}{uint64(0)}))
+	})
+})
+
+// decodeProtobuf returns the values of each field in a protobuf message.
+// Varints and fixed64 values are returned as uint64s, and length-delimited
+// values as strings.
+func decodeProtobuf(b []byte) map[protowire.Number][]interface{} {
+	fields := make(map[protowire.Number][]interface{})
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		Expect(n).To(BeNumerically(">", 0))
+		b = b[n:]
+
+		var value interface{}
+		switch typ {
+		case protowire.VarintType:
+			value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			value, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			var bytes []byte
+			bytes, n = protowire.ConsumeBytes(b)
+			value = string(bytes)
+		default:
+			Fail("unexpected wire type")
+		}
+		Expect(n).To(BeNumerically(">", 0))
+		b = b[n:]
+
+		fields[num] = append(fields[num], value)
+	}
+
+	return fields
+}
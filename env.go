@@ -35,47 +35,67 @@ func getInstanceGuid() string {
 }
 
 func getAppGuid() (string, error) {
+	return getVcapApplicationValue("application_id", "application id")
+}
+
+func getApplicationName() (string, error) {
+	return getVcapApplicationValue("application_name", "application name")
+}
+
+func getSpaceName() (string, error) {
+	return getVcapApplicationValue("space_name", "space name")
+}
+
+func getVcapApplicationValue(key string, description string) (string, error) {
 	var vcapApplication map[string]*json.RawMessage
 	err := json.Unmarshal([]byte(os.Getenv("VCAP_APPLICATION")), &vcapApplication)
 	if err != nil {
 		return "", err
 	}
 
-	appIdJson, ok := vcapApplication["application_id"]
+	valueJson, ok := vcapApplication[key]
 	if !ok {
-		return "", errors.New("Could not find application id")
+		return "", errors.New("Could not find " + description)
 	}
 
-	var appGuid string
-	err = json.Unmarshal(*appIdJson, &appGuid)
+	var value string
+	err = json.Unmarshal(*valueJson, &value)
 	if err != nil {
 		return "", err
 	}
 
-	return appGuid, nil
+	return value, nil
 }
 
 func getCredentials(serviceName string) (serviceCredentials *credentials, err error) {
-	var allServices map[string]*json.RawMessage
-	err = json.Unmarshal([]byte(os.Getenv("VCAP_SERVICES")), &allServices)
+	var creds credentials
+	err = getServiceCredentials(serviceName, &creds)
 	if err != nil {
 		return nil, err
 	}
 
-	forwarderService, err := getService(serviceName)
+	return &creds, nil
+}
+
+// getServiceCredentials unmarshals the credentials of the first binding of a
+// service into creds.
+func getServiceCredentials(serviceName string, creds interface{}) error {
+	service, err := getService(serviceName)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	var serviceValues []map[string]*json.RawMessage
-	err = json.Unmarshal(*forwarderService, &serviceValues)
+	err = json.Unmarshal(*service, &serviceValues)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var creds credentials
-	err = json.Unmarshal(*serviceValues[0]["credentials"], &creds)
-	return &creds, err
+	if len(serviceValues) == 0 || serviceValues[0]["credentials"] == nil {
+		return fmt.Errorf("could not find credentials for service with name: %s", serviceName)
+	}
+
+	return json.Unmarshal(*serviceValues[0]["credentials"], creds)
 }
 
 func getService(serviceName string) (service *json.RawMessage, err error) {
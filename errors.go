@@ -0,0 +1,151 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxErrorBodyBytes    = 4096
+	maxErrorMessageBytes = 256
+)
+
+// ForwarderError is returned when the metrics forwarder, or another HTTP
+// backend, responds with a non-2xx status code. The more specific errors
+// below wrap it, so errors.As can match either the specific error or any
+// ForwarderError.
+type ForwarderError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ForwarderError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Received a non-2xx status code: %d", e.StatusCode)
+	}
+
+	return fmt.Sprintf("Received a non-2xx status code: %d: %s", e.StatusCode, e.Message)
+}
+
+// AuthenticationError is returned when the forwarder rejects the token
+// (401 or 403). An exporter started with StartExporter stops when it sees
+// one.
+type AuthenticationError struct {
+	ForwarderError
+}
+
+func (e *AuthenticationError) Unwrap() error {
+	return &e.ForwarderError
+}
+
+// PayloadTooLargeError is returned when the forwarder rejects a request body
+// as too large (413). The exporter splits the batch and tries again.
+type PayloadTooLargeError struct {
+	ForwarderError
+}
+
+func (e *PayloadTooLargeError) Unwrap() error {
+	return &e.ForwarderError
+}
+
+// RateLimitError is returned when the forwarder is throttling requests
+// (429). RetryAfter is how long the forwarder asked us to wait, or 0 if it
+// did not say. The exporter pauses sends for that long and merges the
+// batches it skipped into the next send.
+type RateLimitError struct {
+	ForwarderError
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return &e.ForwarderError
+}
+
+// ServerError is returned when the forwarder fails with a 5xx status code.
+type ServerError struct {
+	ForwarderError
+}
+
+func (e *ServerError) Unwrap() error {
+	return &e.ForwarderError
+}
+
+// NewForwarderError reads the error body of res and returns the error
+// matching its status code, for transporters that send to an HTTP backend.
+func NewForwarderError(res *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodyBytes))
+
+	base := ForwarderError{
+		StatusCode: res.StatusCode,
+		Message:    parseErrorMessage(body),
+	}
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return &AuthenticationError{ForwarderError: base}
+	case res.StatusCode == http.StatusRequestEntityTooLarge:
+		return &PayloadTooLargeError{ForwarderError: base}
+	case res.StatusCode == http.StatusTooManyRequests:
+		return &RateLimitError{
+			ForwarderError: base,
+			RetryAfter:     retryAfter(res.Header, time.Now()),
+		}
+	case res.StatusCode >= 500:
+		return &ServerError{ForwarderError: base}
+	default:
+		return &base
+	}
+}
+
+// parseErrorMessage returns the message from a JSON error body such as
+// {"error": "..."} or {"message": "..."}, or the start of the body itself
+// when it is not JSON.
+func parseErrorMessage(body []byte) string {
+	var parsed struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+
+	err := json.Unmarshal(body, &parsed)
+	if err == nil {
+		if parsed.Message != "" {
+			return parsed.Message
+		}
+		if parsed.Error != "" {
+			return parsed.Error
+		}
+	}
+
+	message := strings.TrimSpace(string(body))
+	if len(message) > maxErrorMessageBytes {
+		// Cut before the rune that crosses the limit rather than in the
+		// middle of it.
+		end := maxErrorMessageBytes
+		for end > 0 && !utf8.RuneStart(message[end]) {
+			end--
+		}
+		message = message[:end] + "..."
+	}
+
+	return message
+}
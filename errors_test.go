@@ -0,0 +1,141 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+)
+
+var _ = Describe("Forwarder errors", func() {
+	var response = func(statusCode int, body string) *http.Response {
+		return &http.Response{
+			StatusCode: statusCode,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+		}
+	}
+
+	It("returns an AuthenticationError for 401 and 403", func() {
+		for _, statusCode := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+			err := pcfmetrics.NewForwarderError(response(statusCode, ""))
+
+			var authErr *pcfmetrics.AuthenticationError
+			Expect(errors.As(err, &authErr)).To(BeTrue())
+			Expect(authErr.StatusCode).To(Equal(statusCode))
+
+			var forwarderErr *pcfmetrics.ForwarderError
+			Expect(errors.As(err, &forwarderErr)).To(BeTrue())
+			Expect(forwarderErr.StatusCode).To(Equal(statusCode))
+		}
+	})
+
+	It("returns a PayloadTooLargeError for 413", func() {
+		err := pcfmetrics.NewForwarderError(response(http.StatusRequestEntityTooLarge, ""))
+
+		var tooLargeErr *pcfmetrics.PayloadTooLargeError
+		Expect(errors.As(err, &tooLargeErr)).To(BeTrue())
+		Expect(tooLargeErr.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+
+		var forwarderErr *pcfmetrics.ForwarderError
+		Expect(errors.As(err, &forwarderErr)).To(BeTrue())
+	})
+
+	It("returns a RateLimitError with the Retry-After delay for 429", func() {
+		res := response(http.StatusTooManyRequests, "")
+		res.Header.Set("Retry-After", "30")
+
+		err := pcfmetrics.NewForwarderError(res)
+
+		var rateLimitErr *pcfmetrics.RateLimitError
+		Expect(errors.As(err, &rateLimitErr)).To(BeTrue())
+		Expect(rateLimitErr.StatusCode).To(Equal(http.StatusTooManyRequests))
+		Expect(rateLimitErr.RetryAfter).To(Equal(30 * time.Second))
+
+		var forwarderErr *pcfmetrics.ForwarderError
+		Expect(errors.As(err, &forwarderErr)).To(BeTrue())
+	})
+
+	It("returns a ServerError for 5xx", func() {
+		err := pcfmetrics.NewForwarderError(response(http.StatusBadGateway, ""))
+
+		var serverErr *pcfmetrics.ServerError
+		Expect(errors.As(err, &serverErr)).To(BeTrue())
+		Expect(serverErr.StatusCode).To(Equal(http.StatusBadGateway))
+
+		var forwarderErr *pcfmetrics.ForwarderError
+		Expect(errors.As(err, &forwarderErr)).To(BeTrue())
+	})
+
+	It("returns a plain ForwarderError for other status codes", func() {
+		err := pcfmetrics.NewForwarderError(response(http.StatusBadRequest, ""))
+
+		var forwarderErr *pcfmetrics.ForwarderError
+		Expect(errors.As(err, &forwarderErr)).To(BeTrue())
+		Expect(forwarderErr.StatusCode).To(Equal(http.StatusBadRequest))
+
+		var authErr *pcfmetrics.AuthenticationError
+		Expect(errors.As(err, &authErr)).To(BeFalse())
+		var serverErr *pcfmetrics.ServerError
+		Expect(errors.As(err, &serverErr)).To(BeFalse())
+	})
+
+	It("reads the message from a JSON error body", func() {
+		err := pcfmetrics.NewForwarderError(response(http.StatusBadRequest, `{"error": "invalid metric name"}`))
+		Expect(err.Error()).To(Equal("Received a non-2xx status code: 400: invalid metric name"))
+
+		err = pcfmetrics.NewForwarderError(response(http.StatusBadRequest, `{"message": "invalid metric type"}`))
+		Expect(err.Error()).To(Equal("Received a non-2xx status code: 400: invalid metric type"))
+	})
+
+	It("uses a plain text body as the message", func() {
+		err := pcfmetrics.NewForwarderError(response(http.StatusBadGateway, "  upstream unavailable\n"))
+
+		var forwarderErr *pcfmetrics.ForwarderError
+		Expect(errors.As(err, &forwarderErr)).To(BeTrue())
+		Expect(forwarderErr.Message).To(Equal("upstream unavailable"))
+	})
+
+	It("truncates long messages", func() {
+		err := pcfmetrics.NewForwarderError(response(http.StatusBadGateway, strings.Repeat("a", 1000)))
+
+		var forwarderErr *pcfmetrics.ForwarderError
+		Expect(errors.As(err, &forwarderErr)).To(BeTrue())
+		Expect(forwarderErr.Message).To(Equal(strings.Repeat("a", 256) + "..."))
+	})
+
+	It("truncates long messages between runes", func() {
+		err := pcfmetrics.NewForwarderError(response(http.StatusBadGateway, "a"+strings.Repeat("é", 500)))
+
+		var forwarderErr *pcfmetrics.ForwarderError
+		Expect(errors.As(err, &forwarderErr)).To(BeTrue())
+		Expect(utf8.ValidString(forwarderErr.Message)).To(BeTrue())
+		Expect(forwarderErr.Message).To(Equal("a" + strings.Repeat("é", 127) + "..."))
+	})
+
+	It("leaves out the message when the body is empty", func() {
+		err := pcfmetrics.NewForwarderError(response(http.StatusBadGateway, ""))
+		Expect(err.Error()).To(Equal("Received a non-2xx status code: 502"))
+	})
+})
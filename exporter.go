@@ -15,12 +15,14 @@
 package pcfmetrics
 
 import (
+	"errors"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
 	"time"
 
-	"crypto/tls"
-
 	"github.com/rcrowley/go-metrics"
 )
 
@@ -33,33 +35,89 @@ import (
 
 const defaultCfMetricsServiceName = "metrics-forwarder"
 
-type dataPoint struct {
+const (
+	defaultDialTimeout         = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultRequestTimeout      = 30 * time.Second
+	defaultMaxIdleConns        = 10
+	defaultMaxIdleConnsPerHost = 2
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// DataPoint is a single value converted from a metric in the registry.
+// Timers, histograms and meters are converted to several data points that
+// share the same Metric.
+type DataPoint struct {
 	Name      string  `json:"name"`
 	Type      string  `json:"type"`
 	Value     float64 `json:"value"`
 	Timestamp int64   `json:"timestamp"`
 	Unit      string  `json:"unit"`
+
+	// Metric is the name the metric was registered under.
+	Metric string `json:"-"`
+}
+
+// SortByTimestamp returns a copy of points in timestamp order. Points with
+// the same timestamp keep their order. Backends that send counter deltas use
+// it so that batches merged from a backlog are counted oldest first.
+func SortByTimestamp(points []*DataPoint) []*DataPoint {
+	sorted := make([]*DataPoint, len(points))
+	copy(sorted, points)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp < sorted[j].Timestamp
+	})
+
+	return sorted
 }
 
-type transporter interface {
-	sendMetrics([]*dataPoint) error
+// Transporter sends a batch of data points to a metrics backend. It is
+// called from a single go-routine once per interval.
+type Transporter interface {
+	SendMetrics([]*DataPoint) error
 }
 
 type exporter struct {
-	transport transporter
-	timeUnit  time.Duration
+	transport    Transporter
+	timeUnit     time.Duration
+	backlog      *batchBacklog
+	mergeBacklog bool
+	onError      func(error)
+
+	// stopOnAuthError is only set for the metrics forwarder, whose
+	// credentials come from the service binding and do not change while
+	// the app runs.
+	stopOnAuthError bool
 }
 
-func newExporter(transport transporter, timeUnit time.Duration) *exporter {
+func newExporter(transport Transporter, timeUnit time.Duration) *exporter {
 	return &exporter{
 		transport: transport,
 		timeUnit:  timeUnit,
+		onError:   logExportError,
 	}
 }
 
 // StartExporter starts a new exporter on the current go-routine and will
 // never exit.
 func StartExporter(registry metrics.Registry, opts ...ExporterOption) func() {
+	options := newOptions(opts)
+
+	return StartExporterWithOptions(registry, options)
+}
+
+// StartExporterWithTransporter starts a new exporter that sends metrics with
+// transport instead of to the PCF metrics forwarder. Only the options that
+// are not specific to the metrics forwarder, such as the frequency, time
+// unit, backlog and circuit breaker, are used. Unlike StartExporter, it
+// keeps exporting after transport returns an AuthenticationError.
+func StartExporterWithTransporter(registry metrics.Registry, transport Transporter, opts ...ExporterOption) func() {
+	options := newOptions(opts)
+
+	return startExporter(registry, transport, options, options.BacklogSize, false)
+}
+
+func newOptions(opts []ExporterOption) *Options {
 	options := &Options{
 		Frequency:     time.Minute,
 		InstanceIndex: getInstanceIndex(),
@@ -72,7 +130,20 @@ func StartExporter(registry metrics.Registry, opts ...ExporterOption) func() {
 		o(options)
 	}
 
-	return StartExporterWithOptions(registry, options)
+	return options
+}
+
+// InstanceOptions returns the options that identify the app instance, for
+// transporters that send the app GUID, instance ID or instance index. Values
+// that opts do not set are read from the environment as they are for
+// StartExporter.
+func InstanceOptions(opts ...ExporterOption) *Options {
+	options := newOptions(opts)
+	if options.AppGuid == "" {
+		options.fillAppGuidDefault()
+	}
+
+	return options
 }
 
 // StartExporterWithOptions starts a new exporter with provided options on
@@ -85,9 +156,62 @@ func StartExporterWithOptions(registry metrics.Registry, options *Options) func(
 		return func() {}
 	}
 
-	client := createClient(options)
+	if options.Compression != "" {
+		_, err := lookupCompression(options.Compression)
+		if err != nil {
+			log.Printf("Could not export metrics to PCF: %s", err.Error())
+			return func() {}
+		}
+	}
+
+	client := options.HttpClient
+	if client == nil {
+		httpClient, err := createClient(options)
+		if err != nil {
+			log.Printf("Could not export metrics to PCF: %s", err.Error())
+			return func() {}
+		}
+		client = httpClient
+	}
+
 	transport := newHttpTransporter(client, options)
+	if options.SpoolDirectory != "" {
+		spool, err := newDiskSpool(options.SpoolDirectory, options.SpoolMaxBytes)
+		if err != nil {
+			log.Printf("Could not create metrics spool: %s", err.Error())
+		} else {
+			transport.spool = spool
+		}
+	}
+
+	backlogSize := options.BacklogSize
+	if transport.spool != nil {
+		backlogSize = 0
+	}
+
+	return startExporter(registry, transport, options, backlogSize, true)
+}
+
+func startExporter(registry metrics.Registry, transport Transporter, options *Options, backlogSize int, stopOnAuthError bool) func() {
+	if options.Frequency == time.Duration(0) {
+		options.Frequency = time.Minute
+	}
+
+	if options.CircuitFailureThreshold > 0 {
+		transport = newCircuitBreaker(transport, options)
+	}
+
 	exporter := newExporter(transport, options.TimeUnit)
+	if options.OnError != nil {
+		exporter.onError = options.OnError
+	}
+	exporter.stopOnAuthError = stopOnAuthError
+
+	if backlogSize > 0 {
+		exporter.backlog = newBatchBacklog(backlogSize)
+		exporter.backlog.register(registry)
+		exporter.mergeBacklog = options.MergeBacklog
+	}
 
 	stopChan := make(chan struct{})
 
@@ -98,12 +222,40 @@ func StartExporterWithOptions(registry metrics.Registry, options *Options) func(
 	}
 }
 
-func createClient(options *Options) *http.Client {
+func createClient(options *Options) (*http.Client, error) {
+	tlsConfig, err := createTLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if options.ProxyURL != "" {
+		proxyURL, err := url.Parse(options.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   options.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
 	httpTransport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: options.SkipSSLVerification},
+		Proxy:               proxy,
+		DialContext:         dialer.DialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: options.TLSHandshakeTimeout,
+		MaxIdleConns:        options.MaxIdleConns,
+		MaxIdleConnsPerHost: options.MaxIdleConnsPerHost,
+		IdleConnTimeout:     options.IdleConnTimeout,
+	}
+	client := &http.Client{
+		Transport: httpTransport,
+		Timeout:   options.RequestTimeout,
 	}
-	client := &http.Client{Transport: httpTransport}
-	return client
+	return client, nil
 }
 
 func (e *exporter) exportMetricsAtFrequency(registry metrics.Registry, frequency time.Duration, stopChan chan struct{}) {
@@ -117,23 +269,86 @@ func (e *exporter) exportMetricsAtFrequency(registry metrics.Registry, frequency
 
 			err := e.sendMetricsBatch(registry)
 			if err != nil {
-				log.Printf("Could not export metrics to PCF: %s", err.Error())
+				e.onError(err)
+			}
+
+			var authErr *AuthenticationError
+			if e.stopOnAuthError && errors.As(err, &authErr) {
+				log.Println("Stopped exporting metrics to PCF: the metrics forwarder rejected the credentials")
+				return
 			}
 		}
 	}
 }
 
+func logExportError(err error) {
+	if errors.Is(err, ErrCircuitOpen) {
+		return
+	}
+
+	log.Printf("Could not export metrics to PCF: %s", err.Error())
+}
+
 func (e *exporter) sendMetricsBatch(registry metrics.Registry) error {
 	dataPoints := e.assembleDataPoints(registry)
 
-	return e.transport.sendMetrics(dataPoints)
+	err := e.transport.SendMetrics(dataPoints)
+	if err != nil {
+		if e.backlog != nil {
+			e.backlog.push(unsentPoints(dataPoints, err))
+		}
+		return err
+	}
+
+	return e.drainBacklog()
 }
 
-func (e *exporter) assembleDataPoints(registry metrics.Registry) []*dataPoint {
-	var data []*dataPoint
+// drainBacklog sends the batches that failed on earlier ticks, oldest first.
+// The points of a batch that could not be sent stay in the backlog.
+func (e *exporter) drainBacklog() error {
+	if e.backlog == nil {
+		return nil
+	}
+
+	if e.mergeBacklog {
+		points := e.backlog.all()
+		if len(points) == 0 {
+			return nil
+		}
+
+		err := e.transport.SendMetrics(points)
+		e.backlog.clear()
+		if err != nil {
+			e.backlog.push(unsentPoints(points, err))
+			return err
+		}
+
+		return nil
+	}
+
+	for {
+		points, ok := e.backlog.oldest()
+		if !ok {
+			return nil
+		}
+
+		err := e.transport.SendMetrics(points)
+		if err != nil {
+			e.backlog.replaceOldest(unsentPoints(points, err))
+			return err
+		}
+
+		e.backlog.pop()
+	}
+}
+
+func (e *exporter) assembleDataPoints(registry metrics.Registry) []*DataPoint {
+	var data []*DataPoint
 	currentTime := currentTimeInMillis()
 
 	registry.Each(func(name string, metric interface{}) {
+		start := len(data)
+
 		switch m := metric.(type) {
 		case metrics.Counter:
 			data = append(data, convertCounter(m.Snapshot(), name))
@@ -148,6 +363,10 @@ func (e *exporter) assembleDataPoints(registry metrics.Registry) []*dataPoint {
 		case metrics.Histogram:
 			data = append(data, convertHistogram(m.Snapshot(), name)...)
 		}
+
+		for _, dataPoint := range data[start:] {
+			dataPoint.Metric = name
+		}
 	})
 
 	for _, dataPoint := range data {
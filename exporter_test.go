@@ -15,11 +15,24 @@
 package pcfmetrics_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
-	. "github.com/Benjamintf1/Expanded-Unmarshalled-Matchers"
+	. "github.com/benjamintf1/unmarshalledmatchers"
 
 	metricFakes "github.com/pivotal-cf/go-metrics-pcf/go-metrics-pcffakes"
 	"github.com/rcrowley/go-metrics"
@@ -30,6 +43,8 @@ import (
 	"encoding/json"
 	"os"
 	"fmt"
+	"path/filepath"
+	"sync/atomic"
 )
 
 type metricForwarderPayload struct {
@@ -503,6 +518,1245 @@ var _ = Describe("`go-metrics` exporter for PCF Metrics", func() {
 		Eventually(tc.requestBodies).Should(HaveLen(2))
 	})
 
+	Describe("retries", func() {
+		var (
+			registry      metrics.Registry
+			server        *httptest.Server
+			requestCodes  chan int
+			responseCodes []int
+			stopFunc      func()
+		)
+
+		BeforeEach(func() {
+			registry = metrics.NewRegistry()
+			requestCodes = make(chan int, 100)
+			responseCodes = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusOK}
+
+			server = httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, req *http.Request) {
+						code := http.StatusOK
+						if len(requestCodes) < len(responseCodes) {
+							code = responseCodes[len(requestCodes)]
+						}
+
+						requestCodes <- code
+						w.WriteHeader(code)
+					},
+				),
+			)
+
+			registry.Register("test-counter", metrics.NewCounter())
+		})
+
+		AfterEach(func() {
+			stopFunc()
+			server.CloseClientConnections()
+			server.Close()
+		})
+
+		It("retries a failed batch with backoff until it succeeds", func() {
+			stopFunc = pcfmetrics.StartExporter(
+				registry,
+				pcfmetrics.WithFrequency(time.Second),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(server.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithRetryMaxAttempts(3),
+				pcfmetrics.WithRetryBackoff(10*time.Millisecond, 50*time.Millisecond),
+			)
+
+			Eventually(requestCodes, 1.5).Should(HaveLen(3))
+		})
+
+		It("does not retry when retries are not configured", func() {
+			stopFunc = pcfmetrics.StartExporter(
+				registry,
+				pcfmetrics.WithFrequency(time.Second),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(server.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+			)
+
+			Eventually(requestCodes, 1.5).Should(HaveLen(1))
+			Consistently(requestCodes, 0.4).Should(HaveLen(1))
+		})
+
+		It("does not retry client errors", func() {
+			responseCodes = []int{http.StatusBadRequest}
+
+			stopFunc = pcfmetrics.StartExporter(
+				registry,
+				pcfmetrics.WithFrequency(time.Second),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(server.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithRetryMaxAttempts(3),
+				pcfmetrics.WithRetryBackoff(10*time.Millisecond, 50*time.Millisecond),
+			)
+
+			Eventually(requestCodes, 1.5).Should(HaveLen(1))
+			Consistently(requestCodes, 0.4).Should(HaveLen(1))
+		})
+
+		It("gives up when the retry deadline passes", func() {
+			responseCodes = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+
+			stopFunc = pcfmetrics.StartExporter(
+				registry,
+				pcfmetrics.WithFrequency(time.Second),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(server.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithRetryMaxAttempts(3),
+				pcfmetrics.WithRetryBackoff(400*time.Millisecond, 400*time.Millisecond),
+				pcfmetrics.WithRetryDeadline(150*time.Millisecond),
+			)
+
+			Eventually(requestCodes, 1.5).Should(HaveLen(1))
+			Consistently(requestCodes, 0.4).Should(HaveLen(1))
+		})
+	})
+
+	Describe("spooling", func() {
+		var spoolDir string
+
+		var startWithSpool = func(tc *testContext, maxBytes int64) {
+			tc.stopFunc = pcfmetrics.StartExporter(
+				tc.registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(tc.fakeMetricsForwarderServer.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithSpool(spoolDir, maxBytes),
+			)
+		}
+
+		var spoolFiles = func() []string {
+			files, err := filepath.Glob(filepath.Join(spoolDir, "*.spool"))
+			Expect(err).ToNot(HaveOccurred())
+			return files
+		}
+
+		BeforeEach(func() {
+			var err error
+			spoolDir, err = ioutil.TempDir("", "pcfmetrics-spool")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(spoolDir)
+		})
+
+		It("replays batches spooled by a previous exporter once sends succeed", func() {
+			failing := setup(http.StatusInternalServerError)
+			failing.registry.Register("test-counter", metrics.NewCounter())
+			startWithSpool(failing, 0)
+
+			Eventually(failing.requestBodies).Should(HaveLen(2))
+			teardown(failing)
+			Eventually(spoolFiles).ShouldNot(BeEmpty())
+
+			restartTime := time.Now()
+			tc := setup(http.StatusOK)
+			defer teardown(tc)
+			tc.registry.Register("test-counter", metrics.NewCounter())
+			startWithSpool(tc, 0)
+
+			Eventually(tc.requestBodies).Should(HaveLen(3))
+			Eventually(spoolFiles).Should(BeEmpty())
+
+			var replayedBeforeRestart bool
+			for len(tc.requestBodies) > 0 {
+				var payload metricForwarderPayload
+				Expect(json.Unmarshal(<-tc.requestBodies, &payload)).To(Succeed())
+
+				point := payload.Applications[0].Instances[0].Metrics[0]
+				sentAt := time.Unix(0, *point.Timestamp*int64(time.Millisecond))
+				if sentAt.Before(restartTime) {
+					replayedBeforeRestart = true
+				}
+			}
+			Expect(replayedBeforeRestart).To(BeTrue())
+		})
+
+		It("spools the batches the circuit breaker rejects", func() {
+			failing := setup(http.StatusInternalServerError)
+			failing.registry.Register("test-counter", metrics.NewCounter())
+			failing.stopFunc = pcfmetrics.StartExporter(
+				failing.registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(failing.fakeMetricsForwarderServer.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithSpool(spoolDir, 0),
+				pcfmetrics.WithCircuitBreaker(1, time.Minute, 1),
+				pcfmetrics.WithCircuitStateChange(func(from, to pcfmetrics.CircuitState) {}),
+			)
+
+			Eventually(failing.requestBodies).Should(HaveLen(1))
+			time.Sleep(500 * time.Millisecond)
+			teardown(failing)
+			Expect(failing.requestBodies).To(HaveLen(1))
+
+			restartTime := time.Now()
+			tc := setup(http.StatusOK)
+			defer teardown(tc)
+			tc.registry.Register("test-counter", metrics.NewCounter())
+			startWithSpool(tc, 0)
+
+			Eventually(spoolFiles).Should(BeEmpty())
+
+			replayed := 0
+			for len(tc.requestBodies) > 0 {
+				var payload metricForwarderPayload
+				Expect(json.Unmarshal(<-tc.requestBodies, &payload)).To(Succeed())
+
+				point := payload.Applications[0].Instances[0].Metrics[0]
+				sentAt := time.Unix(0, *point.Timestamp*int64(time.Millisecond))
+				if sentAt.Before(restartTime) {
+					replayed++
+				}
+			}
+			Expect(replayed).To(BeNumerically(">=", 4))
+		})
+
+		It("spools new batches after a record that was only partly written", func() {
+			record, err := json.Marshal(map[string]interface{}{
+				"applications": []interface{}{map[string]interface{}{
+					"id": "fake-app-guid",
+					"instances": []interface{}{map[string]interface{}{
+						"metrics": []interface{}{map[string]interface{}{
+							"name": "old-counter", "type": "counter", "value": 1, "timestamp": 1500000000000,
+						}},
+					}},
+				}},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			var segment bytes.Buffer
+			binary.Write(&segment, binary.BigEndian, uint32(len(record)))
+			segment.Write(record)
+			binary.Write(&segment, binary.BigEndian, uint32(1000))
+			segment.WriteString(`{"applic`)
+			Expect(ioutil.WriteFile(filepath.Join(spoolDir, "00000000000000000001.spool"), segment.Bytes(), 0600)).To(Succeed())
+
+			bodies := make(chan []byte, 100)
+			server := httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, req *http.Request) {
+						body, err := ioutil.ReadAll(req.Body)
+						Expect(err).ToNot(HaveOccurred())
+
+						bodies <- body
+						if len(bodies) == 1 {
+							w.WriteHeader(http.StatusInternalServerError)
+						}
+					},
+				),
+			)
+			defer server.Close()
+
+			registry := metrics.NewRegistry()
+			registry.Register("test-counter", metrics.NewCounter())
+			stopFunc := pcfmetrics.StartExporter(
+				registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(server.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithSpool(spoolDir, 0),
+			)
+			defer stopFunc()
+
+			Eventually(spoolFiles).Should(BeEmpty())
+
+			var failedTimestamp int64
+			var replayedOld, replayedFailed bool
+			for len(bodies) > 0 {
+				var payload metricForwarderPayload
+				Expect(json.Unmarshal(<-bodies, &payload)).To(Succeed())
+
+				point := payload.Applications[0].Instances[0].Metrics[0]
+				switch {
+				case point.Name == "old-counter":
+					replayedOld = true
+				case failedTimestamp == 0:
+					failedTimestamp = *point.Timestamp
+				case *point.Timestamp == failedTimestamp:
+					replayedFailed = true
+				}
+			}
+			Expect(replayedOld).To(BeTrue())
+			Expect(replayedFailed).To(BeTrue())
+		})
+
+		It("drops spooled batches that the forwarder rejects", func() {
+			failing := setup(http.StatusInternalServerError)
+			failing.registry.Register("test-counter", metrics.NewCounter())
+			startWithSpool(failing, 0)
+
+			Eventually(failing.requestBodies).Should(HaveLen(2))
+			teardown(failing)
+			Eventually(spoolFiles).ShouldNot(BeEmpty())
+
+			restartTime := time.Now()
+			rejected := make(chan []byte, 100)
+			server := httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, req *http.Request) {
+						body, err := ioutil.ReadAll(req.Body)
+						Expect(err).ToNot(HaveOccurred())
+
+						var payload metricForwarderPayload
+						Expect(json.Unmarshal(body, &payload)).To(Succeed())
+
+						point := payload.Applications[0].Instances[0].Metrics[0]
+						if time.Unix(0, *point.Timestamp*int64(time.Millisecond)).Before(restartTime) {
+							rejected <- body
+							w.WriteHeader(http.StatusBadRequest)
+						}
+					},
+				),
+			)
+			defer server.Close()
+
+			registry := metrics.NewRegistry()
+			registry.Register("test-counter", metrics.NewCounter())
+			stopFunc := pcfmetrics.StartExporter(
+				registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(server.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithSpool(spoolDir, 0),
+			)
+			defer stopFunc()
+
+			Eventually(spoolFiles).Should(BeEmpty())
+			Expect(len(rejected)).To(BeNumerically(">=", 2))
+		})
+
+		It("evicts the oldest batches to stay under the size cap", func() {
+			tc := setup(http.StatusInternalServerError)
+			tc.registry.Register("test-counter", metrics.NewCounter())
+			startWithSpool(tc, 1024)
+
+			Eventually(tc.requestBodies, 3).Should(HaveLen(15))
+			teardown(tc)
+
+			// The batch being spooled when the exporter stopped is evicted
+			// right after it is written.
+			spoolSize := func() int64 {
+				var total int64
+				for _, file := range spoolFiles() {
+					info, err := os.Stat(file)
+					if err == nil {
+						total += info.Size()
+					}
+				}
+				return total
+			}
+			Eventually(spoolSize).Should(BeNumerically("<=", 1024))
+			Expect(spoolSize()).To(BeNumerically(">", 0))
+		})
+	})
+
+	Describe("backlog", func() {
+		var (
+			registry      metrics.Registry
+			server        *httptest.Server
+			requestBodies chan []byte
+			responseCode  int64
+			stopFunc      func()
+		)
+
+		var startWithBacklog = func(size int, merge bool) {
+			stopFunc = pcfmetrics.StartExporter(
+				registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(server.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithBacklog(size, merge),
+			)
+		}
+
+		var pointCounts = func() []int {
+			var counts []int
+			for len(requestBodies) > 0 {
+				var payload metricForwarderPayload
+				Expect(json.Unmarshal(<-requestBodies, &payload)).To(Succeed())
+				counts = append(counts, len(payload.Applications[0].Instances[0].Metrics))
+			}
+			return counts
+		}
+
+		BeforeEach(func() {
+			registry = metrics.NewRegistry()
+			requestBodies = make(chan []byte, 100)
+			atomic.StoreInt64(&responseCode, http.StatusBadGateway)
+
+			server = httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, req *http.Request) {
+						body, err := ioutil.ReadAll(req.Body)
+						Expect(err).ToNot(HaveOccurred())
+
+						code := int(atomic.LoadInt64(&responseCode))
+						if code == http.StatusOK {
+							requestBodies <- body
+						}
+						w.WriteHeader(code)
+					},
+				),
+			)
+
+			registry.Register("test-counter", metrics.NewCounter())
+		})
+
+		AfterEach(func() {
+			stopFunc()
+			server.CloseClientConnections()
+			server.Close()
+		})
+
+		It("replays unsent batches one request at a time", func() {
+			startWithBacklog(2, false)
+
+			Eventually(func() int64 {
+				return registry.Get("pcfmetrics.backlog.depth").(metrics.Gauge).Value()
+			}).Should(BeEquivalentTo(2))
+
+			atomic.StoreInt64(&responseCode, http.StatusOK)
+			Eventually(requestBodies).Should(HaveLen(3))
+			Eventually(func() int64 {
+				return registry.Get("pcfmetrics.backlog.depth").(metrics.Gauge).Value()
+			}).Should(BeEquivalentTo(0))
+		})
+
+		It("replays unsent batches merged into one request", func() {
+			startWithBacklog(2, true)
+
+			Eventually(func() int64 {
+				return registry.Get("pcfmetrics.backlog.depth").(metrics.Gauge).Value()
+			}).Should(BeEquivalentTo(2))
+
+			atomic.StoreInt64(&responseCode, http.StatusOK)
+			Eventually(requestBodies).Should(HaveLen(2))
+			stopFunc()
+			stopFunc = func() {}
+
+			counts := pointCounts()
+			Expect(counts[1]).To(Equal(2 * counts[0]))
+		})
+
+		It("counts batches dropped because the backlog is full", func() {
+			startWithBacklog(1, false)
+
+			Eventually(func() int64 {
+				return registry.Get("pcfmetrics.backlog.dropped").(metrics.Counter).Count()
+			}).Should(BeNumerically(">", 0))
+			Expect(registry.Get("pcfmetrics.backlog.depth").(metrics.Gauge).Value()).To(BeEquivalentTo(1))
+		})
+
+		It("keeps only the chunks of a split batch that could not be sent", func() {
+			var failing int64 = 1
+			accepted := make(chan *metric, 1000)
+			splitServer := httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, req *http.Request) {
+						body, err := ioutil.ReadAll(req.Body)
+						Expect(err).ToNot(HaveOccurred())
+
+						var payload metricForwarderPayload
+						Expect(json.Unmarshal(body, &payload)).To(Succeed())
+
+						points := payload.Applications[0].Instances[0].Metrics
+						for _, point := range points {
+							if point.Name == "test-counter-b" && atomic.LoadInt64(&failing) == 1 {
+								w.WriteHeader(http.StatusBadGateway)
+								return
+							}
+						}
+
+						for _, point := range points {
+							accepted <- point
+						}
+					},
+				),
+			)
+			defer splitServer.Close()
+
+			registry.Register("test-counter-a", metrics.NewCounter())
+			registry.Register("test-counter-b", metrics.NewCounter())
+			stopFunc = pcfmetrics.StartExporter(
+				registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(splitServer.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithMaxPointsPerRequest(1),
+				pcfmetrics.WithBacklog(10, false),
+			)
+
+			Eventually(func() int64 {
+				return registry.Get("pcfmetrics.backlog.depth").(metrics.Gauge).Value()
+			}).Should(BeEquivalentTo(2))
+
+			recoveredAt := time.Now()
+			atomic.StoreInt64(&failing, 0)
+			Eventually(func() int64 {
+				return registry.Get("pcfmetrics.backlog.depth").(metrics.Gauge).Value()
+			}).Should(BeEquivalentTo(0))
+			stopFunc()
+			stopFunc = func() {}
+
+			sent := map[string]int{}
+			var replayed int
+			for len(accepted) > 0 {
+				point := <-accepted
+				if point.Name != "test-counter-a" && point.Name != "test-counter-b" {
+					continue
+				}
+
+				sent[fmt.Sprintf("%s@%d", point.Name, *point.Timestamp)]++
+				if point.Name == "test-counter-b" && time.Unix(0, *point.Timestamp*int64(time.Millisecond)).Before(recoveredAt) {
+					replayed++
+				}
+			}
+
+			Expect(replayed).To(BeNumerically(">=", 2))
+			for key, count := range sent {
+				Expect(count).To(Equal(1), key)
+			}
+		})
+	})
+
+	Describe("compression", func() {
+		var startWithCompression = func(tc *testContext, encoding string, minBytes int) {
+			tc.stopFunc = pcfmetrics.StartExporter(
+				tc.registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(tc.fakeMetricsForwarderServer.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithCompression(encoding, minBytes),
+			)
+		}
+
+		var expectedJson = metricsToJsonString([]*metric{
+			{
+				Name:  "test-counter",
+				Type:  "counter",
+				Value: 6,
+				Unit:  "",
+			},
+		})
+
+		It("gzips request bodies", func() {
+			tc := setup(http.StatusOK)
+			defer teardown(tc)
+
+			counter := metrics.NewCounter()
+			counter.Inc(6)
+			tc.registry.Register("test-counter", counter)
+			startWithCompression(tc, pcfmetrics.CompressionGzip, 1)
+
+			var req *http.Request
+			Eventually(tc.requests).Should(Receive(&req))
+			Expect(req.Header.Get("Content-Encoding")).To(Equal("gzip"))
+
+			var body []byte
+			Eventually(tc.requestBodies).Should(Receive(&body))
+			r, err := gzip.NewReader(bytes.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			payload, err := ioutil.ReadAll(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(payload)).To(ContainUnorderedJSON(expectedJson))
+		})
+
+		It("does not compress bodies smaller than the threshold", func() {
+			tc := setup(http.StatusOK)
+			defer teardown(tc)
+
+			counter := metrics.NewCounter()
+			counter.Inc(6)
+			tc.registry.Register("test-counter", counter)
+			startWithCompression(tc, pcfmetrics.CompressionGzip, 0)
+
+			var req *http.Request
+			Eventually(tc.requests).Should(Receive(&req))
+			Expect(req.Header.Get("Content-Encoding")).To(BeEmpty())
+
+			var payload []byte
+			Eventually(tc.requestBodies).Should(Receive(&payload))
+			Expect(string(payload)).To(ContainUnorderedJSON(expectedJson))
+		})
+
+		It("does not start with an encoding that is not registered", func() {
+			tc := setup(http.StatusOK)
+			defer teardown(tc)
+
+			counter := metrics.NewCounter()
+			counter.Inc(6)
+			tc.registry.Register("test-counter", counter)
+			startWithCompression(tc, pcfmetrics.CompressionZstd, 1)
+
+			Consistently(tc.requests, 0.3).Should(BeEmpty())
+		})
+	})
+
+	Describe("splitting payloads", func() {
+		var startWithLimits = func(tc *testContext, opts ...pcfmetrics.ExporterOption) {
+			tc.stopFunc = pcfmetrics.StartExporter(
+				tc.registry,
+				append([]pcfmetrics.ExporterOption{
+					pcfmetrics.WithFrequency(100 * time.Millisecond),
+					pcfmetrics.WithToken("fake-token"),
+					pcfmetrics.WithURL(tc.fakeMetricsForwarderServer.URL),
+					pcfmetrics.WithAppGuid("fake-app-guid"),
+				}, opts...)...,
+			)
+		}
+
+		var receiveMetrics = func(tc *testContext) []*metric {
+			var body []byte
+			Eventually(tc.requestBodies).Should(Receive(&body))
+
+			var payload metricForwarderPayload
+			Expect(json.Unmarshal(body, &payload)).To(Succeed())
+			return payload.Applications[0].Instances[0].Metrics
+		}
+
+		It("splits batches with more points than the limit", func() {
+			tc := setup(http.StatusOK)
+			defer teardown(tc)
+
+			for i := 0; i < 6; i++ {
+				tc.registry.Register(fmt.Sprintf("test-counter-%d", i), metrics.NewCounter())
+			}
+			startWithLimits(tc, pcfmetrics.WithMaxPointsPerRequest(4))
+
+			Expect(receiveMetrics(tc)).To(HaveLen(4))
+			Expect(receiveMetrics(tc)).To(HaveLen(2))
+		})
+
+		It("splits batches that are larger than the limit", func() {
+			tc := setup(http.StatusOK)
+			defer teardown(tc)
+
+			for i := 0; i < 6; i++ {
+				tc.registry.Register(fmt.Sprintf("test-counter-%d", i), metrics.NewCounter())
+			}
+			startWithLimits(tc, pcfmetrics.WithMaxPayloadBytes(400))
+
+			var body []byte
+			Eventually(tc.requestBodies).Should(Receive(&body))
+			Expect(len(body)).To(BeNumerically("<=", 400))
+			Expect(receiveMetrics(tc)).ToNot(BeEmpty())
+		})
+
+		It("does not split the fields of a timer across requests", func() {
+			tc := setup(http.StatusOK)
+			defer teardown(tc)
+
+			tc.registry.Register("test-counter", metrics.NewCounter())
+			tc.registry.Register("test-timer", metrics.NewTimer())
+			startWithLimits(tc, pcfmetrics.WithMaxPointsPerRequest(5))
+
+			var timerPoints []*metric
+			for _, points := range [][]*metric{receiveMetrics(tc), receiveMetrics(tc)} {
+				if len(points) > 1 {
+					timerPoints = points
+				}
+			}
+
+			Expect(timerPoints).To(HaveLen(16))
+			for _, point := range timerPoints {
+				Expect(point.Name).To(HavePrefix("test-timer."))
+			}
+		})
+
+		It("retries all the requests of a split batch within one deadline", func() {
+			tc := setup(http.StatusBadGateway)
+			defer teardown(tc)
+
+			for i := 0; i < 4; i++ {
+				tc.registry.Register(fmt.Sprintf("test-counter-%d", i), metrics.NewCounter())
+			}
+
+			tc.stopFunc = pcfmetrics.StartExporter(
+				tc.registry,
+				pcfmetrics.WithFrequency(2*time.Second),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(tc.fakeMetricsForwarderServer.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithMaxPointsPerRequest(1),
+				pcfmetrics.WithRetryMaxAttempts(100),
+				pcfmetrics.WithRetryBackoff(20*time.Millisecond, 20*time.Millisecond),
+				pcfmetrics.WithRetryDeadline(300*time.Millisecond),
+			)
+
+			Eventually(tc.requests, 3).Should(Receive())
+			first := time.Now()
+
+			var last time.Time
+			Consistently(func() time.Duration {
+				for len(tc.requests) > 0 {
+					<-tc.requests
+					last = time.Now()
+				}
+				return last.Sub(first)
+			}, 1.5).Should(BeNumerically("<", 450*time.Millisecond))
+		})
+	})
+
+	Describe("forwarder errors", func() {
+		It("stops exporting when the forwarder rejects the credentials", func() {
+			tc := setupAndStart(http.StatusUnauthorized)
+			defer teardown(tc)
+
+			tc.registry.Register("test-counter", metrics.NewCounter())
+
+			Eventually(tc.requests).Should(HaveLen(1))
+			Consistently(tc.requests, 0.5).Should(HaveLen(1))
+		})
+
+		It("passes the errors to the error handler", func() {
+			tc := setup(http.StatusUnauthorized)
+			defer teardown(tc)
+
+			tc.registry.Register("test-counter", metrics.NewCounter())
+
+			exportErrors := make(chan error, 10)
+			tc.stopFunc = pcfmetrics.StartExporter(
+				tc.registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(tc.fakeMetricsForwarderServer.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithErrorHandler(func(err error) {
+					exportErrors <- err
+				}),
+			)
+
+			var err error
+			Eventually(exportErrors).Should(Receive(&err))
+
+			var authErr *pcfmetrics.AuthenticationError
+			Expect(errors.As(err, &authErr)).To(BeTrue())
+			Expect(authErr.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("splits a batch that the forwarder rejects as too large", func() {
+			registry := metrics.NewRegistry()
+			for i := 0; i < 4; i++ {
+				registry.Register(fmt.Sprintf("test-counter-%d", i), metrics.NewCounter())
+			}
+
+			acceptedSizes := make(chan int, 100)
+			server := httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, req *http.Request) {
+						body, err := ioutil.ReadAll(req.Body)
+						Expect(err).ToNot(HaveOccurred())
+
+						var payload metricForwarderPayload
+						Expect(json.Unmarshal(body, &payload)).To(Succeed())
+
+						points := len(payload.Applications[0].Instances[0].Metrics)
+						if points > 1 {
+							w.WriteHeader(http.StatusRequestEntityTooLarge)
+							w.Write([]byte(`{"error": "payload too large"}`))
+							return
+						}
+
+						acceptedSizes <- points
+					},
+				),
+			)
+			defer server.Close()
+
+			stopFunc := pcfmetrics.StartExporter(
+				registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(server.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+			)
+			defer stopFunc()
+
+			Eventually(acceptedSizes).Should(HaveLen(4))
+		})
+	})
+
+	Describe("rate limiting", func() {
+		var (
+			registry      metrics.Registry
+			server        *httptest.Server
+			requestBodies chan []byte
+			stopFunc      func()
+		)
+
+		BeforeEach(func() {
+			registry = metrics.NewRegistry()
+			requestBodies = make(chan []byte, 100)
+
+			server = httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, req *http.Request) {
+						body, err := ioutil.ReadAll(req.Body)
+						Expect(err).ToNot(HaveOccurred())
+
+						requestBodies <- body
+						if len(requestBodies) == 1 {
+							w.Header().Set("Retry-After", "1")
+							w.WriteHeader(http.StatusTooManyRequests)
+						}
+					},
+				),
+			)
+
+			registry.Register("test-counter", metrics.NewCounter())
+
+			stopFunc = pcfmetrics.StartExporter(
+				registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(server.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+			)
+		})
+
+		AfterEach(func() {
+			stopFunc()
+			server.CloseClientConnections()
+			server.Close()
+		})
+
+		It("pauses sends for as long as Retry-After asks", func() {
+			Eventually(requestBodies).Should(HaveLen(1))
+			Consistently(requestBodies, 0.7).Should(HaveLen(1))
+			Eventually(requestBodies, 1).Should(HaveLen(2))
+		})
+
+		It("merges the skipped intervals into the next send", func() {
+			Eventually(requestBodies, 2).Should(HaveLen(2))
+			<-requestBodies
+
+			var payload metricForwarderPayload
+			Expect(json.Unmarshal(<-requestBodies, &payload)).To(Succeed())
+
+			points := payload.Applications[0].Instances[0].Metrics
+			Expect(points).To(HaveLen(1))
+			Expect(points[0].Name).To(Equal("test-counter"))
+		})
+
+		It("defers the remaining chunks of a split batch", func() {
+			chunkBodies := make(chan []byte, 100)
+			chunkServer := httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, req *http.Request) {
+						body, err := ioutil.ReadAll(req.Body)
+						Expect(err).ToNot(HaveOccurred())
+
+						chunkBodies <- body
+						if len(chunkBodies) == 1 {
+							w.Header().Set("Retry-After", "1")
+							w.WriteHeader(http.StatusTooManyRequests)
+						}
+					},
+				),
+			)
+			defer chunkServer.Close()
+
+			chunkRegistry := metrics.NewRegistry()
+			chunkRegistry.Register("first-counter", metrics.NewCounter())
+			chunkRegistry.Register("second-counter", metrics.NewCounter())
+
+			stop := pcfmetrics.StartExporter(
+				chunkRegistry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(chunkServer.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithMaxPointsPerRequest(1),
+			)
+			defer stop()
+
+			Eventually(chunkBodies).Should(HaveLen(1))
+			Consistently(chunkBodies, 0.7).Should(HaveLen(1))
+			Eventually(chunkBodies, 1).Should(HaveLen(3))
+		})
+	})
+
+	Describe("circuit breaker", func() {
+		type transition struct {
+			from, to pcfmetrics.CircuitState
+		}
+
+		var startWithBreaker = func(tc *testContext, transitions chan transition) {
+			tc.stopFunc = pcfmetrics.StartExporter(
+				tc.registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(tc.fakeMetricsForwarderServer.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithCircuitBreaker(2, 500*time.Millisecond, 1),
+				pcfmetrics.WithCircuitStateChange(func(from, to pcfmetrics.CircuitState) {
+					transitions <- transition{from: from, to: to}
+				}),
+			)
+		}
+
+		It("stops sending while the breaker is open and probes after the timeout", func() {
+			tc := setup(http.StatusInternalServerError)
+			defer teardown(tc)
+
+			transitions := make(chan transition, 100)
+			startWithBreaker(tc, transitions)
+
+			Eventually(transitions).Should(Receive(Equal(transition{pcfmetrics.CircuitClosed, pcfmetrics.CircuitOpen})))
+			Expect(tc.requests).To(HaveLen(2))
+			Consistently(tc.requests, 0.3).Should(HaveLen(2))
+
+			Eventually(transitions).Should(Receive(Equal(transition{pcfmetrics.CircuitOpen, pcfmetrics.CircuitHalfOpen})))
+			Eventually(transitions).Should(Receive(Equal(transition{pcfmetrics.CircuitHalfOpen, pcfmetrics.CircuitOpen})))
+			Expect(tc.requests).To(HaveLen(3))
+		})
+
+		It("closes again once the forwarder recovers", func() {
+			tc := setup(http.StatusOK)
+			defer teardown(tc)
+
+			responseCode := int64(http.StatusInternalServerError)
+			tc.fakeMetricsForwarderServer.Config.Handler = http.HandlerFunc(
+				func(w http.ResponseWriter, req *http.Request) {
+					tc.requests <- req
+					w.WriteHeader(int(atomic.LoadInt64(&responseCode)))
+				},
+			)
+
+			transitions := make(chan transition, 100)
+			startWithBreaker(tc, transitions)
+
+			Eventually(transitions).Should(Receive(Equal(transition{pcfmetrics.CircuitClosed, pcfmetrics.CircuitOpen})))
+			atomic.StoreInt64(&responseCode, http.StatusOK)
+
+			Eventually(transitions).Should(Receive(Equal(transition{pcfmetrics.CircuitOpen, pcfmetrics.CircuitHalfOpen})))
+			Eventually(transitions).Should(Receive(Equal(transition{pcfmetrics.CircuitHalfOpen, pcfmetrics.CircuitClosed})))
+		})
+	})
+
+	Describe("TLS", func() {
+		var (
+			registry metrics.Registry
+			server   *httptest.Server
+			requests chan *http.Request
+			stopFunc func()
+		)
+
+		var serverCAPEM = func() []byte {
+			return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		}
+
+		var start = func(opts ...pcfmetrics.ExporterOption) {
+			stopFunc = pcfmetrics.StartExporter(
+				registry,
+				append([]pcfmetrics.ExporterOption{
+					pcfmetrics.WithFrequency(100 * time.Millisecond),
+					pcfmetrics.WithToken("fake-token"),
+					pcfmetrics.WithURL(server.URL),
+					pcfmetrics.WithAppGuid("fake-app-guid"),
+				}, opts...)...,
+			)
+		}
+
+		BeforeEach(func() {
+			registry = metrics.NewRegistry()
+			registry.Register("test-counter", metrics.NewCounter())
+			requests = make(chan *http.Request, 100)
+
+			server = httptest.NewUnstartedServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, req *http.Request) {
+						requests <- req
+					},
+				),
+			)
+		})
+
+		AfterEach(func() {
+			stopFunc()
+			server.Close()
+		})
+
+		It("does not trust the forwarder's CA by default", func() {
+			server.StartTLS()
+			start()
+
+			Consistently(requests, 0.3).Should(BeEmpty())
+		})
+
+		It("trusts extra root CAs from a PEM file", func() {
+			server.StartTLS()
+
+			caFile, err := ioutil.TempFile("", "pcfmetrics-ca")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.Remove(caFile.Name())
+			_, err = caFile.Write(serverCAPEM())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(caFile.Close()).To(Succeed())
+
+			start(pcfmetrics.WithRootCAFiles(caFile.Name()))
+
+			Eventually(requests).Should(Receive())
+		})
+
+		It("trusts extra root CAs from the CF system cert path", func() {
+			server.StartTLS()
+
+			certDir, err := ioutil.TempDir("", "pcfmetrics-certs")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(certDir)
+			Expect(ioutil.WriteFile(filepath.Join(certDir, "forwarder.crt"), serverCAPEM(), 0600)).To(Succeed())
+
+			os.Setenv("CF_SYSTEM_CERT_PATH", certDir)
+			defer os.Unsetenv("CF_SYSTEM_CERT_PATH")
+
+			start(pcfmetrics.WithCFSystemCerts())
+
+			Eventually(requests).Should(Receive())
+		})
+
+		It("presents a client certificate for mutual TLS", func() {
+			clientCert, clientKey := generateCertificate()
+			clientCAs := x509.NewCertPool()
+			Expect(clientCAs.AppendCertsFromPEM(clientCert)).To(BeTrue())
+
+			server.TLS = &tls.Config{
+				ClientAuth: tls.RequireAndVerifyClientCert,
+				ClientCAs:  clientCAs,
+			}
+			server.StartTLS()
+
+			start(
+				pcfmetrics.WithRootCAPEM(serverCAPEM()),
+				pcfmetrics.WithClientCertificatePEM(clientCert, clientKey),
+				pcfmetrics.WithMinTLSVersion(tls.VersionTLS12),
+			)
+
+			var req *http.Request
+			Eventually(requests).Should(Receive(&req))
+			Expect(req.TLS.PeerCertificates).To(HaveLen(1))
+		})
+
+		It("does not start when the client certificate cannot be loaded", func() {
+			server.StartTLS()
+			start(pcfmetrics.WithClientCertificate("/does/not/exist.crt", "/does/not/exist.key"))
+
+			Consistently(requests, 0.3).Should(BeEmpty())
+		})
+	})
+
+	Describe("connections", func() {
+		It("sends requests through the configured proxy", func() {
+			proxiedHosts := make(chan string, 100)
+			proxy := httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, req *http.Request) {
+						proxiedHosts <- req.URL.Host
+					},
+				),
+			)
+			defer proxy.Close()
+
+			registry := metrics.NewRegistry()
+			registry.Register("test-counter", metrics.NewCounter())
+
+			stopFunc := pcfmetrics.StartExporter(
+				registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL("http://metrics-forwarder.example.com/v1/metrics"),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithProxy(proxy.URL),
+			)
+			defer stopFunc()
+
+			Eventually(proxiedHosts).Should(Receive(Equal("metrics-forwarder.example.com")))
+		})
+
+		It("reuses connections between batches", func() {
+			requests := make(chan *http.Request, 100)
+			server := httptest.NewUnstartedServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, req *http.Request) {
+						requests <- req
+						w.WriteHeader(http.StatusInternalServerError)
+						w.Write(bytes.Repeat([]byte("x"), 1024*1024))
+					},
+				),
+			)
+
+			var newConnections int64
+			server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					atomic.AddInt64(&newConnections, 1)
+				}
+			}
+			server.Start()
+			defer server.Close()
+
+			registry := metrics.NewRegistry()
+			registry.Register("test-counter", metrics.NewCounter())
+			stopFunc := pcfmetrics.StartExporter(
+				registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(server.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithTimeouts(time.Second, time.Second, time.Second),
+				pcfmetrics.WithIdleConnections(1, 1, time.Minute),
+			)
+			defer stopFunc()
+
+			Eventually(requests).Should(HaveLen(5))
+			Expect(atomic.LoadInt64(&newConnections)).To(BeEquivalentTo(1))
+		})
+	})
+
+	Describe("custom HTTP clients", func() {
+		It("sends requests with the client from WithHttpClient", func() {
+			tc := setup(http.StatusOK)
+			defer teardown(tc)
+
+			client := &countingClient{}
+			tc.registry.Register("test-counter", metrics.NewCounter())
+			tc.stopFunc = pcfmetrics.StartExporter(
+				tc.registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(tc.fakeMetricsForwarderServer.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithHttpClient(client),
+			)
+
+			Eventually(tc.requests).Should(Receive())
+			Expect(atomic.LoadInt64(&client.calls)).To(BeNumerically(">", 0))
+		})
+
+		It("lets a hook change each request before it is sent", func() {
+			tc := setup(http.StatusOK)
+			defer teardown(tc)
+
+			tc.registry.Register("test-counter", metrics.NewCounter())
+			tc.stopFunc = pcfmetrics.StartExporter(
+				tc.registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(tc.fakeMetricsForwarderServer.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithHttpClient(&countingClient{}),
+				pcfmetrics.WithRequestHook(func(req *http.Request) error {
+					req.Header.Set("X-Signature", "fake-signature")
+					return nil
+				}),
+			)
+
+			var req *http.Request
+			Eventually(tc.requests).Should(Receive(&req))
+			Expect(req.Header.Get("X-Signature")).To(Equal("fake-signature"))
+			Expect(req.Header.Get("Authorization")).To(Equal("fake-token"))
+		})
+
+		It("does not send a request when the hook fails", func() {
+			tc := setup(http.StatusOK)
+			defer teardown(tc)
+
+			tc.registry.Register("test-counter", metrics.NewCounter())
+			tc.stopFunc = pcfmetrics.StartExporter(
+				tc.registry,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithToken("fake-token"),
+				pcfmetrics.WithURL(tc.fakeMetricsForwarderServer.URL),
+				pcfmetrics.WithAppGuid("fake-app-guid"),
+				pcfmetrics.WithRequestHook(func(req *http.Request) error {
+					return errors.New("could not sign request")
+				}),
+			)
+
+			Consistently(tc.requests, 0.3).Should(BeEmpty())
+		})
+	})
+
+	Describe("custom transporters", func() {
+		It("sends data points to the transporter", func() {
+			registry := metrics.NewRegistry()
+			counter := metrics.NewCounter()
+			counter.Inc(6)
+			registry.Register("test-counter", counter)
+
+			transport := &fakeTransporter{batches: make(chan []*pcfmetrics.DataPoint, 100)}
+			stopFunc := pcfmetrics.StartExporterWithTransporter(
+				registry,
+				transport,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+			)
+			defer stopFunc()
+
+			var batch []*pcfmetrics.DataPoint
+			Eventually(transport.batches).Should(Receive(&batch))
+			Expect(batch).To(HaveLen(1))
+			Expect(batch[0].Name).To(Equal("test-counter"))
+			Expect(batch[0].Metric).To(Equal("test-counter"))
+			Expect(batch[0].Type).To(Equal("counter"))
+			Expect(batch[0].Value).To(BeEquivalentTo(6))
+		})
+
+		It("marks the data points of a timer with the timer's name", func() {
+			registry := metrics.NewRegistry()
+			registry.Register("test-timer", metrics.NewTimer())
+
+			transport := &fakeTransporter{batches: make(chan []*pcfmetrics.DataPoint, 100)}
+			stopFunc := pcfmetrics.StartExporterWithTransporter(
+				registry,
+				transport,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+			)
+			defer stopFunc()
+
+			var batch []*pcfmetrics.DataPoint
+			Eventually(transport.batches).Should(Receive(&batch))
+			Expect(batch).To(HaveLen(16))
+			for _, point := range batch {
+				Expect(point.Metric).To(Equal("test-timer"))
+			}
+		})
+
+		It("keeps exporting when the transporter returns an AuthenticationError", func() {
+			registry := metrics.NewRegistry()
+			registry.Register("test-counter", metrics.NewCounter())
+
+			transport := &fakeTransporter{
+				batches: make(chan []*pcfmetrics.DataPoint, 100),
+				err: &pcfmetrics.AuthenticationError{
+					ForwarderError: pcfmetrics.ForwarderError{StatusCode: http.StatusUnauthorized},
+				},
+			}
+			stopFunc := pcfmetrics.StartExporterWithTransporter(
+				registry,
+				transport,
+				pcfmetrics.WithFrequency(100*time.Millisecond),
+				pcfmetrics.WithErrorHandler(func(error) {}),
+			)
+			defer stopFunc()
+
+			Eventually(transport.batches).Should(HaveLen(3))
+		})
+	})
+
 	Describe("default options", func() {
 		It("uses milliseconds as the default timer time unit", func() {
 			tc := setupAndStart(http.StatusOK)
@@ -767,6 +2021,25 @@ var _ = Describe("`go-metrics` exporter for PCF Metrics", func() {
 	})
 })
 
+type fakeTransporter struct {
+	batches chan []*pcfmetrics.DataPoint
+	err     error
+}
+
+func (f *fakeTransporter) SendMetrics(points []*pcfmetrics.DataPoint) error {
+	f.batches <- points
+	return f.err
+}
+
+type countingClient struct {
+	calls int64
+}
+
+func (c *countingClient) Do(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&c.calls, 1)
+	return http.DefaultClient.Do(req)
+}
+
 func metricsToJsonString(metrics []*metric) string {
 	bytes, err := json.Marshal(wrapMetrics(metrics))
 	Expect(err).ToNot(HaveOccurred())
@@ -790,3 +2063,43 @@ func wrapMetrics(metrics []*metric) metricForwarderPayload {
 		},
 	}
 }
+
+func generateCertificate() (certPEM, keyPEM []byte) {
+	return signCertificate(certificateTemplate())
+}
+
+// generateServerCertificate generates a certificate that verifies for
+// servers listening on 127.0.0.1.
+func generateServerCertificate() (certPEM, keyPEM []byte) {
+	template := certificateTemplate()
+	template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	return signCertificate(template)
+}
+
+func certificateTemplate() *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "go-metrics-pcf-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+}
+
+func signCertificate(template *x509.Certificate) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
@@ -0,0 +1,200 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	GraphitePlaintext = "plaintext"
+	GraphitePickle    = "pickle"
+)
+
+const (
+	defaultGraphiteWriteTimeout = 5 * time.Second
+	graphiteMaxWriteBytes       = 64 * 1024
+	graphiteMaxPicklePoints     = 500
+)
+
+// GraphiteOptions configures a GraphiteTransporter.
+type GraphiteOptions struct {
+	// Address is the host and port of the Carbon receiver.
+	Address string
+
+	// Protocol is either GraphitePlaintext or GraphitePickle. It defaults to
+	// GraphitePlaintext.
+	Protocol string
+
+	// Prefix is prepended to every metric path.
+	Prefix string
+
+	// WriteTimeout defaults to 5 seconds.
+	WriteTimeout time.Duration
+}
+
+// GraphiteTransporter sends data points to Carbon over TCP. The dotted data
+// point names are used as the metric paths.
+type GraphiteTransporter struct {
+	options GraphiteOptions
+	conn    *reconnectingConn
+}
+
+// NewGraphiteTransporter returns a transporter for
+// StartExporterWithTransporter that sends metrics to Carbon. The connection
+// is opened on the first send and opened again after a write fails.
+func NewGraphiteTransporter(options GraphiteOptions) *GraphiteTransporter {
+	if options.Protocol == "" {
+		options.Protocol = GraphitePlaintext
+	}
+	if options.WriteTimeout == 0 {
+		options.WriteTimeout = defaultGraphiteWriteTimeout
+	}
+
+	return &GraphiteTransporter{
+		options: options,
+		conn: &reconnectingConn{
+			network: "tcp",
+			address: options.Address,
+			timeout: options.WriteTimeout,
+		},
+	}
+}
+
+func (t *GraphiteTransporter) SendMetrics(points []*DataPoint) error {
+	var messages [][]byte
+	if t.options.Protocol == GraphitePickle {
+		messages = t.pickleMessages(points)
+	} else {
+		messages = t.plaintextMessages(points)
+	}
+
+	for _, message := range messages {
+		err := t.write(message)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the connection to Carbon.
+func (t *GraphiteTransporter) Close() error {
+	return t.conn.Close()
+}
+
+// write retries once on a new connection, because a write to a connection
+// that Carbon has closed only fails after the connection is reset. Carbon
+// keeps the last value for a path and timestamp, so sending a message twice
+// is harmless.
+func (t *GraphiteTransporter) write(message []byte) error {
+	_, err := t.conn.Write(message)
+	if err != nil {
+		_, err = t.conn.Write(message)
+	}
+
+	return err
+}
+
+func (t *GraphiteTransporter) plaintextMessages(points []*DataPoint) [][]byte {
+	var lines []string
+	for _, point := range points {
+		if math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+			continue
+		}
+
+		lines = append(lines, graphitePath(t.options.Prefix, point.Name)+" "+
+			strconv.FormatFloat(point.Value, 'f', -1, 64)+" "+
+			strconv.FormatInt(point.Timestamp/1000, 10))
+	}
+
+	return packLines(lines, graphiteMaxWriteBytes)
+}
+
+func (t *GraphiteTransporter) pickleMessages(points []*DataPoint) [][]byte {
+	var messages [][]byte
+	var batch []*DataPoint
+
+	for _, point := range points {
+		if math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+			continue
+		}
+
+		batch = append(batch, point)
+		if len(batch) == graphiteMaxPicklePoints {
+			messages = append(messages, t.encodePickle(batch))
+			batch = nil
+		}
+	}
+
+	if len(batch) > 0 {
+		messages = append(messages, t.encodePickle(batch))
+	}
+
+	return messages
+}
+
+// encodePickle encodes a list of (path, (timestamp, value)) tuples with
+// pickle protocol 2, preceded by its length as Carbon expects.
+func (t *GraphiteTransporter) encodePickle(points []*DataPoint) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0x80, 0x02}) // PROTO 2
+	buf.WriteByte(']')            // EMPTY_LIST
+	buf.WriteByte('(')            // MARK
+
+	for _, point := range points {
+		path := graphitePath(t.options.Prefix, point.Name)
+		buf.WriteByte('X') // BINUNICODE
+		binary.Write(&buf, binary.LittleEndian, uint32(len(path)))
+		buf.WriteString(path)
+
+		buf.WriteByte('J') // BININT
+		binary.Write(&buf, binary.LittleEndian, int32(point.Timestamp/1000))
+		buf.WriteByte('G') // BINFLOAT
+		binary.Write(&buf, binary.BigEndian, point.Value)
+
+		buf.WriteByte(0x86) // TUPLE2 (timestamp, value)
+		buf.WriteByte(0x86) // TUPLE2 (path, (timestamp, value))
+	}
+
+	buf.WriteByte('e') // APPENDS
+	buf.WriteByte('.') // STOP
+
+	message := make([]byte, 4, 4+buf.Len())
+	binary.BigEndian.PutUint32(message, uint32(buf.Len()))
+	return append(message, buf.Bytes()...)
+}
+
+// graphitePath replaces the whitespace that separates the fields of a
+// plaintext line with underscores.
+func graphitePath(prefix, name string) string {
+	if prefix != "" {
+		name = joinNameParts(prefix, name)
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n':
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,123 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+)
+
+var _ = Describe("Graphite transporter", func() {
+	var (
+		listener    net.Listener
+		connections chan net.Conn
+		points      []*pcfmetrics.DataPoint
+	)
+
+	BeforeEach(func() {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		connections = make(chan net.Conn, 10)
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				connections <- conn
+			}
+		}()
+
+		points = []*pcfmetrics.DataPoint{
+			{Name: "test-timer.duration.99thPercentile", Type: "gauge", Value: 14.5, Timestamp: 1500000000123},
+			{Name: "test counter", Type: "counter", Value: 6, Timestamp: 1500000000123},
+		}
+	})
+
+	AfterEach(func() {
+		listener.Close()
+	})
+
+	It("writes plaintext lines with the prefix", func() {
+		transport := pcfmetrics.NewGraphiteTransporter(pcfmetrics.GraphiteOptions{
+			Address: listener.Addr().String(),
+			Prefix:  "apps.my-app",
+		})
+		defer transport.Close()
+
+		Expect(transport.SendMetrics(points)).To(Succeed())
+
+		var conn net.Conn
+		Eventually(connections).Should(Receive(&conn))
+		reader := bufio.NewReader(conn)
+
+		Expect(reader.ReadString('\n')).To(Equal("apps.my-app.test-timer.duration.99thPercentile 14.5 1500000000\n"))
+		Expect(reader.ReadString('\n')).To(Equal("apps.my-app.test_counter 6 1500000000\n"))
+	})
+
+	It("writes length-prefixed pickle messages", func() {
+		transport := pcfmetrics.NewGraphiteTransporter(pcfmetrics.GraphiteOptions{
+			Address:  listener.Addr().String(),
+			Protocol: pcfmetrics.GraphitePickle,
+		})
+		defer transport.Close()
+
+		Expect(transport.SendMetrics(points)).To(Succeed())
+
+		var conn net.Conn
+		Eventually(connections).Should(Receive(&conn))
+
+		var length uint32
+		Expect(binary.Read(conn, binary.BigEndian, &length)).To(Succeed())
+
+		message := make([]byte, length)
+		_, err := io.ReadFull(conn, message)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(message).To(HavePrefix("\x80\x02](X\x22\x00\x00\x00test-timer.duration.99thPercentileJ\x00\x2f\x68\x59G"))
+		Expect(message).To(ContainSubstring("X\x0c\x00\x00\x00test_counterJ"))
+		Expect(message).To(HaveSuffix("\x86\x86e."))
+	})
+
+	It("reconnects after Carbon closes the connection", func() {
+		transport := pcfmetrics.NewGraphiteTransporter(pcfmetrics.GraphiteOptions{
+			Address: listener.Addr().String(),
+		})
+		defer transport.Close()
+
+		Expect(transport.SendMetrics(points)).To(Succeed())
+
+		var first net.Conn
+		Eventually(connections).Should(Receive(&first))
+		first.Close()
+
+		var second net.Conn
+		Eventually(func() chan net.Conn {
+			Expect(transport.SendMetrics(points)).To(Succeed())
+			return connections
+		}).Should(Receive(&second))
+
+		Expect(bufio.NewReader(second).ReadString('\n')).To(HavePrefix("test-timer.duration.99thPercentile 14.5"))
+	})
+})
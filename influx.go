@@ -0,0 +1,232 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxOptions configures an InfluxTransporter. Metrics are written with
+// the v2 API when Bucket is set and with the v1 API otherwise.
+type InfluxOptions struct {
+	// Url is the base URL of the InfluxDB server, such as
+	// https://influx.example.com:8086.
+	Url string
+
+	// Database, Username and Password are used by the v1 /write API.
+	Database string
+	Username string
+	Password string
+
+	// Org, Bucket and Token are used by the v2 /api/v2/write API.
+	Org    string
+	Bucket string
+	Token  string
+
+	// Tags are added to every measurement.
+	Tags map[string]string
+
+	// Precision is the precision of the timestamps: time.Nanosecond,
+	// time.Microsecond, time.Millisecond or time.Second. It defaults to
+	// time.Millisecond, which is also used for any other value.
+	Precision time.Duration
+
+	// HttpClient defaults to a client with a 30 second timeout.
+	HttpClient HttpClient
+}
+
+// InfluxTransporter writes data points to InfluxDB in the line protocol. The
+// data points of a metric become the fields of one measurement, so a timer
+// is written as a single line with count, mean and percentile fields.
+type InfluxTransporter struct {
+	options InfluxOptions
+	client  HttpClient
+}
+
+// NewInfluxTransporter returns a transporter for
+// StartExporterWithTransporter that writes metrics to InfluxDB.
+func NewInfluxTransporter(options InfluxOptions) *InfluxTransporter {
+	switch options.Precision {
+	case time.Nanosecond, time.Microsecond, time.Millisecond, time.Second:
+	default:
+		options.Precision = time.Millisecond
+	}
+
+	client := options.HttpClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultRequestTimeout}
+	}
+
+	return &InfluxTransporter{
+		options: options,
+		client:  client,
+	}
+}
+
+func (t *InfluxTransporter) SendMetrics(points []*DataPoint) error {
+	body := encodeInfluxLines(points, t.options.Tags, t.options.Precision)
+	if len(body) == 0 {
+		return nil
+	}
+
+	req, err := t.createRequest(body)
+	if err != nil {
+		return err
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return NewForwarderError(res)
+	}
+
+	return nil
+}
+
+func (t *InfluxTransporter) createRequest(body []byte) (*http.Request, error) {
+	writeUrl, err := url.Parse(t.options.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	if t.options.Bucket != "" {
+		writeUrl.Path = strings.TrimSuffix(writeUrl.Path, "/") + "/api/v2/write"
+		query.Set("org", t.options.Org)
+		query.Set("bucket", t.options.Bucket)
+		query.Set("precision", influxPrecision(t.options.Precision, "us"))
+	} else {
+		writeUrl.Path = strings.TrimSuffix(writeUrl.Path, "/") + "/write"
+		query.Set("db", t.options.Database)
+		query.Set("precision", influxPrecision(t.options.Precision, "u"))
+	}
+	writeUrl.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodPost, writeUrl.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if t.options.Token != "" {
+		req.Header.Set("Authorization", "Token "+t.options.Token)
+	} else if t.options.Username != "" {
+		req.SetBasicAuth(t.options.Username, t.options.Password)
+	}
+
+	return req, nil
+}
+
+// influxPrecision returns the precision query parameter. The v1 and v2 APIs
+// only differ in how they spell microseconds.
+func influxPrecision(precision time.Duration, microseconds string) string {
+	switch precision {
+	case time.Nanosecond:
+		return "ns"
+	case time.Microsecond:
+		return microseconds
+	case time.Second:
+		return "s"
+	default:
+		return "ms"
+	}
+}
+
+// encodeInfluxLines writes one line per metric. The measurement is the name
+// the metric was registered under, and each data point is a field named
+// after the rest of its name, or "value" for counters and gauges.
+func encodeInfluxLines(points []*DataPoint, tags map[string]string, precision time.Duration) []byte {
+	var buf bytes.Buffer
+	tagSet := formatInfluxTags(tags)
+
+	for _, group := range GroupPoints(points) {
+		var fields []string
+		for _, point := range group {
+			if math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+				continue
+			}
+
+			key := strings.TrimPrefix(point.Name, point.Metric+".")
+			if key == point.Metric {
+				key = "value"
+			}
+
+			value := strconv.FormatFloat(point.Value, 'f', -1, 64)
+			if point.Type == "counter" {
+				value = strconv.FormatInt(int64(point.Value), 10) + "i"
+			}
+
+			fields = append(fields, escapeInfluxKey(key)+"="+value)
+		}
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		timestamp := group[0].Timestamp * int64(time.Millisecond) / int64(precision)
+
+		buf.WriteString(escapeInfluxMeasurement(group[0].Metric))
+		buf.WriteString(tagSet)
+		buf.WriteByte(' ')
+		buf.WriteString(strings.Join(fields, ","))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatInt(timestamp, 10))
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes()
+}
+
+func formatInfluxTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var tagSet strings.Builder
+	for _, key := range keys {
+		if tags[key] == "" {
+			continue
+		}
+		tagSet.WriteString("," + escapeInfluxKey(key) + "=" + escapeInfluxKey(tags[key]))
+	}
+
+	return tagSet.String()
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	influxKeyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+func escapeInfluxMeasurement(name string) string {
+	return influxMeasurementEscaper.Replace(name)
+}
+
+func escapeInfluxKey(key string) string {
+	return influxKeyEscaper.Replace(key)
+}
@@ -0,0 +1,181 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+	metricFakes "github.com/pivotal-cf/go-metrics-pcf/go-metrics-pcffakes"
+	"github.com/rcrowley/go-metrics"
+)
+
+var _ = Describe("InfluxDB transporter", func() {
+	var (
+		server     *httptest.Server
+		requests   chan *http.Request
+		bodies     chan string
+		statusCode int
+	)
+
+	BeforeEach(func() {
+		requests = make(chan *http.Request, 100)
+		bodies = make(chan string, 100)
+		statusCode = http.StatusNoContent
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			requests <- req
+			bodies <- string(body)
+
+			w.WriteHeader(statusCode)
+			if statusCode != http.StatusNoContent {
+				w.Write([]byte(`{"error":"database not found: \"metrics\""}`))
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("writes a timer as one measurement to the v1 API", func() {
+		fakeTimer := new(metricFakes.FakeTimer)
+		fakeTimer.SnapshotReturns(fakeTimer)
+		fakeTimer.CountReturns(3)
+		fakeTimer.MeanReturns(5 * float64(time.Millisecond))
+		fakeTimer.PercentilesReturns([]float64{0, 0, 0, 14 * float64(time.Millisecond), 0})
+
+		registry := metrics.NewRegistry()
+		registry.Register("test timer", fakeTimer)
+
+		transport := pcfmetrics.NewInfluxTransporter(pcfmetrics.InfluxOptions{
+			Url:      server.URL,
+			Database: "metrics",
+			Username: "user",
+			Password: "pass",
+			Tags:     map[string]string{"instance_index": "1", "app_id": "some-guid"},
+		})
+
+		stop := pcfmetrics.StartExporterWithTransporter(registry, transport, pcfmetrics.WithFrequency(10*time.Millisecond))
+		defer stop()
+
+		var req *http.Request
+		Eventually(requests).Should(Receive(&req))
+		Expect(req.Method).To(Equal(http.MethodPost))
+		Expect(req.URL.Path).To(Equal("/write"))
+		Expect(req.URL.Query().Get("db")).To(Equal("metrics"))
+		Expect(req.URL.Query().Get("precision")).To(Equal("ms"))
+
+		username, password, ok := req.BasicAuth()
+		Expect(ok).To(BeTrue())
+		Expect(username).To(Equal("user"))
+		Expect(password).To(Equal("pass"))
+
+		var body string
+		Eventually(bodies).Should(Receive(&body))
+		Expect(strings.Count(body, "\n")).To(Equal(1))
+		Expect(body).To(HavePrefix(`test\ timer,app_id=some-guid,instance_index=1 count=3i,`))
+		Expect(body).To(ContainSubstring(",duration.mean=5,"))
+		Expect(body).To(ContainSubstring(",duration.99thPercentile=14,"))
+		Expect(body).To(MatchRegexp(` \d{13}\n$`))
+	})
+
+	It("writes to the v2 API at the configured precision", func() {
+		transport := pcfmetrics.NewInfluxTransporter(pcfmetrics.InfluxOptions{
+			Url:       server.URL,
+			Org:       "my-org",
+			Bucket:    "my-bucket",
+			Token:     "some-token",
+			Precision: time.Second,
+		})
+
+		err := transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-gauge", Metric: "test-gauge", Type: "gauge", Value: 32.2, Timestamp: 1500000000123},
+			{Name: "test-counter", Metric: "test-counter", Type: "counter", Value: 6, Timestamp: 1500000000123},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var req *http.Request
+		Eventually(requests).Should(Receive(&req))
+		Expect(req.URL.Path).To(Equal("/api/v2/write"))
+		Expect(req.URL.Query().Get("org")).To(Equal("my-org"))
+		Expect(req.URL.Query().Get("bucket")).To(Equal("my-bucket"))
+		Expect(req.URL.Query().Get("precision")).To(Equal("s"))
+		Expect(req.Header.Get("Authorization")).To(Equal("Token some-token"))
+
+		Eventually(bodies).Should(Receive(Equal(
+			"test-gauge value=32.2 1500000000\ntest-counter value=6i 1500000000\n",
+		)))
+	})
+
+	It("writes a metric from two merged batches as two lines", func() {
+		transport := pcfmetrics.NewInfluxTransporter(pcfmetrics.InfluxOptions{Url: server.URL, Database: "metrics"})
+
+		err := transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-timer.count", Metric: "test-timer", Type: "counter", Value: 3, Timestamp: 1500000000000},
+			{Name: "test-timer.duration.mean", Metric: "test-timer", Type: "gauge", Value: 5, Timestamp: 1500000000000},
+			{Name: "test-timer.count", Metric: "test-timer", Type: "counter", Value: 4, Timestamp: 1500000060000},
+			{Name: "test-timer.duration.mean", Metric: "test-timer", Type: "gauge", Value: 6, Timestamp: 1500000060000},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(bodies).Should(Receive(Equal(
+			"test-timer count=3i,duration.mean=5 1500000000000\n" +
+				"test-timer count=4i,duration.mean=6 1500000060000\n",
+		)))
+	})
+
+	It("uses milliseconds for a precision InfluxDB does not support", func() {
+		transport := pcfmetrics.NewInfluxTransporter(pcfmetrics.InfluxOptions{
+			Url:       server.URL,
+			Database:  "metrics",
+			Precision: time.Minute,
+		})
+
+		err := transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-gauge", Metric: "test-gauge", Type: "gauge", Value: 1, Timestamp: 1500000000123},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var req *http.Request
+		Eventually(requests).Should(Receive(&req))
+		Expect(req.URL.Query().Get("precision")).To(Equal("ms"))
+		Eventually(bodies).Should(Receive(Equal("test-gauge value=1 1500000000123\n")))
+	})
+
+	It("returns the error from InfluxDB", func() {
+		statusCode = http.StatusNotFound
+
+		transport := pcfmetrics.NewInfluxTransporter(pcfmetrics.InfluxOptions{Url: server.URL, Database: "metrics"})
+
+		err := transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-gauge", Metric: "test-gauge", Type: "gauge", Value: 1},
+		})
+
+		var forwarderErr *pcfmetrics.ForwarderError
+		Expect(errors.As(err, &forwarderErr)).To(BeTrue())
+		Expect(forwarderErr.StatusCode).To(Equal(http.StatusNotFound))
+		Expect(forwarderErr.Message).To(Equal(`database not found: "metrics"`))
+	})
+})
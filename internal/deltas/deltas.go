@@ -0,0 +1,104 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package deltas tracks the values of counters that have been sent, for
+// backends that expect the change in a counter rather than its cumulative
+// value, and the times of gauges that have been sent, for backends that do
+// not send timestamps.
+package deltas
+
+// Counters remembers the last value sent for each counter, and the time of
+// the last value sent for each gauge.
+type Counters struct {
+	last map[string]sample
+}
+
+type sample struct {
+	value     float64
+	timestamp int64
+}
+
+func New() *Counters {
+	return &Counters{last: make(map[string]sample)}
+}
+
+// Batch returns a Batch for the counters of one send.
+func (c *Counters) Batch() *Batch {
+	return &Batch{counters: c, pending: make(map[string]sample)}
+}
+
+// Update records the value of a counter once it has been sent, unless a
+// later value has been recorded already.
+func (c *Counters) Update(name string, value float64, timestamp int64) {
+	last, ok := c.last[name]
+	if ok && timestamp < last.timestamp {
+		return
+	}
+
+	c.last[name] = sample{value: value, timestamp: timestamp}
+}
+
+// Batch computes the deltas of the counters in one send. Values are passed
+// to Delta in timestamp order, and are only recorded as sent by Commit.
+type Batch struct {
+	counters *Counters
+	pending  map[string]sample
+}
+
+// Delta returns the change in a counter since the last value sent, or since
+// the value passed to Delta before in the batch. The change is negative when
+// the counter was decremented. It returns false for a value older than that
+// one, which has been counted already.
+func (b *Batch) Delta(name string, value float64, timestamp int64) (float64, bool) {
+	last, ok := b.pending[name]
+	if !ok {
+		last, ok = b.counters.last[name]
+	}
+
+	if ok && timestamp < last.timestamp {
+		return 0, false
+	}
+
+	b.pending[name] = sample{value: value, timestamp: timestamp}
+
+	if !ok {
+		return value, true
+	}
+
+	return value - last.value, true
+}
+
+// Current reports whether a gauge is at least as new as the last value sent,
+// or passed to Current before in the batch, so that gauges replayed from a
+// backlog do not overwrite newer values.
+func (b *Batch) Current(name string, timestamp int64) bool {
+	last, ok := b.pending[name]
+	if !ok {
+		last, ok = b.counters.last[name]
+	}
+
+	if ok && timestamp < last.timestamp {
+		return false
+	}
+
+	b.pending[name] = sample{timestamp: timestamp}
+	return true
+}
+
+// Commit records the values passed to Delta and Current as sent.
+func (b *Batch) Commit() {
+	for name, pending := range b.pending {
+		b.counters.Update(name, pending.value, pending.timestamp)
+	}
+}
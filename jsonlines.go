@@ -0,0 +1,106 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+)
+
+const defaultJSONLinesMarker = "go-metrics-pcf"
+
+// JSONLinesOptions configures a JSONLinesSink.
+type JSONLinesOptions struct {
+	// Writer defaults to os.Stdout, so that metrics leave the container
+	// through the app's log stream.
+	Writer io.Writer
+
+	// Marker is the value of the "marker" field on every line, which lets
+	// log consumers tell metrics apart from other log lines. It defaults to
+	// "go-metrics-pcf".
+	Marker string
+
+	// PerBatch writes one line per batch, with the data points in a
+	// "metrics" field, instead of one line per data point.
+	PerBatch bool
+}
+
+// JSONLinesSink writes data points as newline-delimited JSON, in the same
+// shape as the data points sent to the metrics forwarder.
+type JSONLinesSink struct {
+	options JSONLinesOptions
+}
+
+type jsonLinesPoint struct {
+	Marker string `json:"marker"`
+	*DataPoint
+}
+
+type jsonLinesBatch struct {
+	Marker  string       `json:"marker"`
+	Metrics []*DataPoint `json:"metrics"`
+}
+
+// NewJSONLinesSink returns a transporter for StartExporterWithTransporter
+// that writes metrics as JSON lines.
+func NewJSONLinesSink(options JSONLinesOptions) *JSONLinesSink {
+	if options.Writer == nil {
+		options.Writer = os.Stdout
+	}
+	if options.Marker == "" {
+		options.Marker = defaultJSONLinesMarker
+	}
+
+	return &JSONLinesSink{options: options}
+}
+
+// SendMetrics writes the batch with a single write, so that its lines are
+// not interleaved with other output. Values that JSON cannot represent,
+// such as NaN, are left out.
+func (s *JSONLinesSink) SendMetrics(points []*DataPoint) error {
+	var finite []*DataPoint
+	for _, point := range points {
+		if !math.IsNaN(point.Value) && !math.IsInf(point.Value, 0) {
+			finite = append(finite, point)
+		}
+	}
+
+	if len(finite) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+
+	if s.options.PerBatch {
+		err := encoder.Encode(jsonLinesBatch{Marker: s.options.Marker, Metrics: finite})
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, point := range finite {
+			err := encoder.Encode(jsonLinesPoint{Marker: s.options.Marker, DataPoint: point})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := s.options.Writer.Write(buf.Bytes())
+	return err
+}
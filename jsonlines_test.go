@@ -0,0 +1,91 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+	"github.com/rcrowley/go-metrics"
+)
+
+var _ = Describe("JSON lines sink", func() {
+	var points []*pcfmetrics.DataPoint
+
+	BeforeEach(func() {
+		points = []*pcfmetrics.DataPoint{
+			{Name: "test-counter", Metric: "test-counter", Type: "counter", Value: 6, Timestamp: 1500000000123},
+			{Name: "test-gauge", Metric: "test-gauge", Type: "gauge", Value: 32.2, Timestamp: 1500000000123, Unit: "milliseconds"},
+			{Name: "test-nan", Metric: "test-nan", Type: "gauge", Value: math.NaN(), Timestamp: 1500000000123},
+		}
+	})
+
+	It("writes one line per data point with the marker", func() {
+		var buf bytes.Buffer
+		sink := pcfmetrics.NewJSONLinesSink(pcfmetrics.JSONLinesOptions{Writer: &buf})
+
+		Expect(sink.SendMetrics(points)).To(Succeed())
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).To(MatchJSON(`{"marker":"go-metrics-pcf","name":"test-counter","type":"counter","value":6,"timestamp":1500000000123,"unit":""}`))
+		Expect(lines[1]).To(MatchJSON(`{"marker":"go-metrics-pcf","name":"test-gauge","type":"gauge","value":32.2,"timestamp":1500000000123,"unit":"milliseconds"}`))
+	})
+
+	It("writes one line per batch", func() {
+		var buf bytes.Buffer
+		sink := pcfmetrics.NewJSONLinesSink(pcfmetrics.JSONLinesOptions{
+			Writer:   &buf,
+			Marker:   "my-metrics",
+			PerBatch: true,
+		})
+
+		Expect(sink.SendMetrics(points)).To(Succeed())
+
+		Expect(strings.Count(buf.String(), "\n")).To(Equal(1))
+
+		var batch struct {
+			Marker  string                  `json:"marker"`
+			Metrics []*pcfmetrics.DataPoint `json:"metrics"`
+		}
+		Expect(json.Unmarshal(buf.Bytes(), &batch)).To(Succeed())
+		Expect(batch.Marker).To(Equal("my-metrics"))
+		Expect(batch.Metrics).To(HaveLen(2))
+		Expect(batch.Metrics[1].Name).To(Equal("test-gauge"))
+	})
+
+	It("writes the registry at the exporter's frequency", func() {
+		registry := metrics.NewRegistry()
+		counter := metrics.NewCounter()
+		counter.Inc(3)
+		registry.Register("test-counter", counter)
+
+		buf := gbytes.NewBuffer()
+		sink := pcfmetrics.NewJSONLinesSink(pcfmetrics.JSONLinesOptions{Writer: buf})
+
+		stop := pcfmetrics.StartExporterWithTransporter(registry, sink, pcfmetrics.WithFrequency(10*time.Millisecond))
+		defer stop()
+
+		Eventually(buf).Should(gbytes.Say(`\{"marker":"go-metrics-pcf","name":"test-counter","type":"counter","value":3,`))
+	})
+})
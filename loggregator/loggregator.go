@@ -0,0 +1,238 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package loggregator sends go-metrics data points to the Loggregator agent
+// as v2 envelopes. It is a separate package so that applications that do not
+// use it do not depend on gRPC.
+package loggregator
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	"google.golang.org/grpc"
+	grpccredentials "google.golang.org/grpc/credentials"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+	"github.com/pivotal-cf/go-metrics-pcf/internal/deltas"
+)
+
+const (
+	defaultAddress    = "localhost:3458"
+	defaultServerName = "metron"
+	defaultTimeout    = 5 * time.Second
+)
+
+// Options configures a Transporter.
+type Options struct {
+	// Address is the host and port of the Loggregator agent's v2 API. It
+	// defaults to localhost:3458.
+	Address string
+
+	// CAFile, CertFile and KeyFile are the CA that signed the agent's
+	// certificate, and the client certificate and key.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+
+	// ServerName is the name in the agent's certificate. It defaults to
+	// "metron".
+	ServerName string
+
+	// Tags are added to every envelope.
+	Tags map[string]string
+
+	// Timeout is the timeout for each send. It defaults to 5 seconds.
+	Timeout time.Duration
+}
+
+// Transporter sends data points to the Loggregator agent as v2 envelopes
+// over gRPC. The gauges of a batch are sent in a single gauge
+// envelope, and each counter in a counter envelope with its delta and
+// total.
+type Transporter struct {
+	options    Options
+	conn       *grpc.ClientConn
+	client     loggregator_v2.IngressClient
+	sourceId   string
+	instanceId string
+	counters   *deltas.Counters
+}
+
+// NewTransporter returns a transporter for
+// pcfmetrics.StartExporterWithTransporter that sends metrics to the
+// Loggregator agent. Envelopes use the app GUID in opts as the source ID and
+// the instance index as the instance ID, which default to the values in the
+// environment as they do for pcfmetrics.StartExporter.
+func NewTransporter(loggregatorOptions Options, opts ...pcfmetrics.ExporterOption) (*Transporter, error) {
+	if loggregatorOptions.Address == "" {
+		loggregatorOptions.Address = defaultAddress
+	}
+	if loggregatorOptions.ServerName == "" {
+		loggregatorOptions.ServerName = defaultServerName
+	}
+	if loggregatorOptions.Timeout == 0 {
+		loggregatorOptions.Timeout = defaultTimeout
+	}
+
+	tlsConfig, err := createTLSConfig(loggregatorOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.NewClient(
+		loggregatorOptions.Address,
+		grpc.WithTransportCredentials(grpccredentials.NewTLS(tlsConfig)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	options := pcfmetrics.InstanceOptions(opts...)
+
+	return &Transporter{
+		options:    loggregatorOptions,
+		conn:       conn,
+		client:     loggregator_v2.NewIngressClient(conn),
+		sourceId:   options.AppGuid,
+		instanceId: options.InstanceIndex,
+		counters:   deltas.New(),
+	}, nil
+}
+
+func (t *Transporter) SendMetrics(points []*pcfmetrics.DataPoint) error {
+	var envelopes []*loggregator_v2.Envelope
+	gauges := make(map[int64]*loggregator_v2.Gauge)
+	counters := t.counters.Batch()
+
+	for _, point := range pcfmetrics.SortByTimestamp(points) {
+		if math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+			continue
+		}
+
+		timestamp := point.Timestamp * int64(time.Millisecond)
+
+		if point.Type == "counter" {
+			delta, ok := counters.Delta(point.Name, point.Value, point.Timestamp)
+			if !ok {
+				continue
+			}
+
+			envelope := t.newEnvelope(timestamp)
+			envelope.Message = &loggregator_v2.Envelope_Counter{
+				Counter: &loggregator_v2.Counter{
+					Name:  point.Name,
+					Delta: counterValue(delta),
+					Total: counterValue(point.Value),
+				},
+			}
+			envelopes = append(envelopes, envelope)
+			continue
+		}
+
+		gauge, ok := gauges[timestamp]
+		if !ok {
+			gauge = &loggregator_v2.Gauge{Metrics: make(map[string]*loggregator_v2.GaugeValue)}
+			gauges[timestamp] = gauge
+
+			envelope := t.newEnvelope(timestamp)
+			envelope.Message = &loggregator_v2.Envelope_Gauge{Gauge: gauge}
+			envelopes = append(envelopes, envelope)
+		}
+		gauge.Metrics[point.Name] = &loggregator_v2.GaugeValue{Unit: point.Unit, Value: point.Value}
+	}
+
+	if len(envelopes) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.options.Timeout)
+	defer cancel()
+
+	_, err := t.client.Send(ctx, &loggregator_v2.EnvelopeBatch{Batch: envelopes})
+	if err != nil {
+		return err
+	}
+
+	counters.Commit()
+
+	return nil
+}
+
+// Close closes the connection to the agent.
+func (t *Transporter) Close() error {
+	return t.conn.Close()
+}
+
+func (t *Transporter) newEnvelope(timestamp int64) *loggregator_v2.Envelope {
+	return &loggregator_v2.Envelope{
+		Timestamp:  timestamp,
+		SourceId:   t.sourceId,
+		InstanceId: t.instanceId,
+		Tags:       t.options.Tags,
+	}
+}
+
+// createTLSConfig trusts the CA that signed the agent's certificate in
+// addition to the system roots.
+func createTLSConfig(options Options) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: options.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if options.CAFile != "" {
+		pem, err := ioutil.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", options.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if options.CertFile != "" || options.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// counterValue converts a counter value to the unsigned value of an
+// envelope. Counters that went down are sent as 0 rather than wrapping
+// around.
+func counterValue(value float64) uint64 {
+	if value < 0 {
+		return 0
+	}
+
+	return uint64(value)
+}
@@ -0,0 +1,215 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loggregator_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/go-loggregator/v10/rpc/loggregator_v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+	"github.com/pivotal-cf/go-metrics-pcf/loggregator"
+)
+
+var _ = Describe("Loggregator transporter", func() {
+	var (
+		dir     string
+		server  *grpc.Server
+		ingress *fakeIngressServer
+		address string
+		options loggregator.Options
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "loggregator")
+		Expect(err).ToNot(HaveOccurred())
+
+		certPEM, keyPEM := generateCertificate()
+		Expect(ioutil.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600)).To(Succeed())
+
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		Expect(err).ToNot(HaveOccurred())
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(certPEM)
+
+		server = grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool,
+		})))
+		ingress = &fakeIngressServer{batches: make(chan *loggregator_v2.EnvelopeBatch, 10)}
+		loggregator_v2.RegisterIngressServer(server, ingress)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		address = listener.Addr().String()
+		go server.Serve(listener)
+
+		options = loggregator.Options{
+			Address:  address,
+			CAFile:   filepath.Join(dir, "cert.pem"),
+			CertFile: filepath.Join(dir, "cert.pem"),
+			KeyFile:  filepath.Join(dir, "key.pem"),
+			Tags:     map[string]string{"origin": "my-app"},
+		}
+	})
+
+	AfterEach(func() {
+		server.Stop()
+		os.RemoveAll(dir)
+	})
+
+	It("sends gauges in one envelope and counters with their deltas", func() {
+		transport, err := loggregator.NewTransporter(options,
+			pcfmetrics.WithAppGuid("some-app-guid"),
+			pcfmetrics.WithInstanceIndex("1"),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		defer transport.Close()
+
+		err = transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-counter", Type: "counter", Value: 6, Timestamp: 1500000000123},
+			{Name: "test-gauge", Type: "gauge", Value: 32.2, Timestamp: 1500000000123},
+			{Name: "test-timer.duration.mean", Type: "gauge", Unit: "milliseconds", Value: 5, Timestamp: 1500000000123},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var batch *loggregator_v2.EnvelopeBatch
+		Eventually(ingress.batches).Should(Receive(&batch))
+		Expect(batch.Batch).To(HaveLen(2))
+
+		for _, envelope := range batch.Batch {
+			Expect(envelope.SourceId).To(Equal("some-app-guid"))
+			Expect(envelope.InstanceId).To(Equal("1"))
+			Expect(envelope.Timestamp).To(Equal(int64(1500000000123000000)))
+			Expect(envelope.Tags).To(Equal(map[string]string{"origin": "my-app"}))
+		}
+
+		counter := batch.Batch[0].GetCounter()
+		Expect(counter.Name).To(Equal("test-counter"))
+		Expect(counter.Delta).To(Equal(uint64(6)))
+		Expect(counter.Total).To(Equal(uint64(6)))
+
+		gauge := batch.Batch[1].GetGauge()
+		Expect(gauge.Metrics).To(HaveLen(2))
+		Expect(gauge.Metrics["test-gauge"].Value).To(Equal(32.2))
+		Expect(gauge.Metrics["test-timer.duration.mean"].Unit).To(Equal("milliseconds"))
+
+		err = transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-counter", Type: "counter", Value: 10, Timestamp: 1500000060123},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(ingress.batches).Should(Receive(&batch))
+		Expect(batch.Batch[0].GetCounter().Delta).To(Equal(uint64(4)))
+		Expect(batch.Batch[0].GetCounter().Total).To(Equal(uint64(10)))
+	})
+
+	It("sends counters that went down as 0", func() {
+		transport, err := loggregator.NewTransporter(options)
+		Expect(err).ToNot(HaveOccurred())
+		defer transport.Close()
+
+		err = transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-counter", Type: "counter", Value: 6, Timestamp: 1500000000123},
+			{Name: "test-counter", Type: "counter", Value: 2, Timestamp: 1500000060123},
+			{Name: "negative-counter", Type: "counter", Value: -3, Timestamp: 1500000060123},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var batch *loggregator_v2.EnvelopeBatch
+		Eventually(ingress.batches).Should(Receive(&batch))
+		Expect(batch.Batch).To(HaveLen(3))
+
+		decremented := batch.Batch[1].GetCounter()
+		Expect(decremented.Delta).To(Equal(uint64(0)))
+		Expect(decremented.Total).To(Equal(uint64(2)))
+
+		negative := batch.Batch[2].GetCounter()
+		Expect(negative.Delta).To(Equal(uint64(0)))
+		Expect(negative.Total).To(Equal(uint64(0)))
+	})
+
+	It("fails without a client certificate", func() {
+		options.CertFile = ""
+		options.KeyFile = ""
+
+		transport, err := loggregator.NewTransporter(options)
+		Expect(err).ToNot(HaveOccurred())
+		defer transport.Close()
+
+		err = transport.SendMetrics([]*pcfmetrics.DataPoint{{Name: "test-gauge", Type: "gauge", Value: 1}})
+		Expect(err).To(HaveOccurred())
+		Expect(ingress.batches).ToNot(Receive())
+	})
+})
+
+type fakeIngressServer struct {
+	loggregator_v2.UnimplementedIngressServer
+	batches chan *loggregator_v2.EnvelopeBatch
+}
+
+func (f *fakeIngressServer) Send(ctx context.Context, batch *loggregator_v2.EnvelopeBatch) (*loggregator_v2.SendResponse, error) {
+	f.batches <- batch
+	return &loggregator_v2.SendResponse{}, nil
+}
+
+func generateCertificate() (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "go-metrics-pcf-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"metron"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// acceptsOpenMetrics reports whether an Accept header lists the OpenMetrics
+// media type with a non-zero quality.
+func acceptsOpenMetrics(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil || mediaType != "application/openmetrics-text" {
+			continue
+		}
+
+		if q, ok := params["q"]; ok {
+			quality, err := strconv.ParseFloat(q, 64)
+			if err != nil || quality == 0 {
+				continue
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// writeOpenMetricsText renders metric families in the OpenMetrics text
+// format. Counter samples get the _total suffix, and counters and summaries
+// get a _created sample with the time their metric was first seen.
+func writeOpenMetricsText(w io.Writer, families []*metricFamily, created map[string]time.Time) error {
+	buf := bufio.NewWriter(w)
+
+	for _, family := range families {
+		name := family.name
+		if family.typ == "counter" {
+			name = strings.TrimSuffix(name, "_total")
+		}
+
+		fmt.Fprintf(buf, "# TYPE %s %s\n", name, family.typ)
+		if family.unit != "" {
+			fmt.Fprintf(buf, "# UNIT %s %s\n", name, family.unit)
+		}
+
+		for _, sample := range family.samples {
+			if family.typ == "counter" && sample.suffix == "" {
+				sample.suffix = "_total"
+			}
+			writeSample(buf, name, sample)
+		}
+
+		if first, ok := created[family.metric]; ok && family.typ != "gauge" {
+			writeSample(buf, name, metricSample{
+				suffix: "_created",
+				value:  float64(first.UnixNano()) / float64(time.Second),
+			})
+		}
+	}
+
+	fmt.Fprint(buf, "# EOF\n")
+
+	return buf.Flush()
+}
@@ -17,19 +17,54 @@ package pcfmetrics
 import (
 	"time"
 	"log"
+	"net/http"
 )
 
 // Options is used when starting an exporter.
 type Options struct {
-	Frequency           time.Duration
-	InstanceId          string
-	InstanceIndex       string
-	Token               string
-	Url                 string
-	AppGuid             string
-	TimeUnit            time.Duration
-	ServiceName         string
-	SkipSSLVerification bool
+	Frequency                time.Duration
+	InstanceId               string
+	InstanceIndex            string
+	Token                    string
+	Url                      string
+	AppGuid                  string
+	TimeUnit                 time.Duration
+	ServiceName              string
+	SkipSSLVerification      bool
+	RetryMaxAttempts         int
+	RetryInitialBackoff      time.Duration
+	RetryMaxBackoff          time.Duration
+	RetryDeadline            time.Duration
+	SpoolDirectory           string
+	SpoolMaxBytes            int64
+	BacklogSize              int
+	MergeBacklog             bool
+	Compression              string
+	CompressionThreshold     int
+	MaxPayloadBytes          int
+	MaxPointsPerRequest      int
+	CircuitFailureThreshold  int
+	CircuitOpenTimeout       time.Duration
+	CircuitHalfOpenSuccesses int
+	OnCircuitStateChange     func(from, to CircuitState)
+	OnError                  func(error)
+	RootCAFiles              []string
+	RootCAPEM                []byte
+	UseCFSystemCerts         bool
+	ClientCertFile           string
+	ClientKeyFile            string
+	ClientCertPEM            []byte
+	ClientKeyPEM             []byte
+	MinTLSVersion            uint16
+	ProxyURL                 string
+	DialTimeout              time.Duration
+	TLSHandshakeTimeout      time.Duration
+	RequestTimeout           time.Duration
+	MaxIdleConns             int
+	MaxIdleConnsPerHost      int
+	IdleConnTimeout          time.Duration
+	HttpClient               HttpClient
+	RequestHook              func(*http.Request) error
 }
 
 func (o *Options) fillDefaults() {
@@ -44,6 +79,57 @@ func (o *Options) fillDefaults() {
 	if o.Frequency == time.Duration(0) {
 		o.Frequency = time.Minute
 	}
+
+	if o.Compression != "" && o.CompressionThreshold == 0 {
+		o.CompressionThreshold = defaultCompressionThreshold
+	}
+
+	o.fillRetryDefaults()
+	o.fillConnectionDefaults()
+}
+
+func (o *Options) fillRetryDefaults() {
+	if o.RetryMaxAttempts < 1 {
+		o.RetryMaxAttempts = 1
+	}
+
+	if o.RetryInitialBackoff == time.Duration(0) {
+		o.RetryInitialBackoff = defaultRetryInitialBackoff
+	}
+
+	if o.RetryMaxBackoff == time.Duration(0) {
+		o.RetryMaxBackoff = defaultRetryMaxBackoff
+	}
+
+	if o.RetryDeadline == time.Duration(0) || o.RetryDeadline > o.Frequency {
+		o.RetryDeadline = o.Frequency
+	}
+}
+
+func (o *Options) fillConnectionDefaults() {
+	if o.DialTimeout == time.Duration(0) {
+		o.DialTimeout = defaultDialTimeout
+	}
+
+	if o.TLSHandshakeTimeout == time.Duration(0) {
+		o.TLSHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+
+	if o.RequestTimeout == time.Duration(0) {
+		o.RequestTimeout = defaultRequestTimeout
+	}
+
+	if o.MaxIdleConns == 0 {
+		o.MaxIdleConns = defaultMaxIdleConns
+	}
+
+	if o.MaxIdleConnsPerHost == 0 {
+		o.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+
+	if o.IdleConnTimeout == time.Duration(0) {
+		o.IdleConnTimeout = defaultIdleConnTimeout
+	}
 }
 
 func (o *Options) fillCredentialDefaults() {
@@ -139,3 +225,208 @@ func WithSkipSSL(skip bool) ExporterOption {
 		o.SkipSSLVerification = skip
 	}
 }
+
+// WithRootCAFiles trusts the CAs in the given PEM files in addition to the
+// system roots.
+func WithRootCAFiles(paths ...string) ExporterOption {
+	return func(o *Options) {
+		o.RootCAFiles = append(o.RootCAFiles, paths...)
+	}
+}
+
+// WithRootCAPEM trusts the PEM encoded CAs in addition to the system roots.
+func WithRootCAPEM(pem []byte) ExporterOption {
+	return func(o *Options) {
+		o.RootCAPEM = append(o.RootCAPEM, pem...)
+	}
+}
+
+// WithCFSystemCerts trusts the certificates in the directory named by the
+// CF_SYSTEM_CERT_PATH environment variable in addition to the system roots.
+func WithCFSystemCerts() ExporterOption {
+	return func(o *Options) {
+		o.UseCFSystemCerts = true
+	}
+}
+
+// WithClientCertificate sets the certificate and key files used for mutual
+// TLS.
+func WithClientCertificate(certFile, keyFile string) ExporterOption {
+	return func(o *Options) {
+		o.ClientCertFile = certFile
+		o.ClientKeyFile = keyFile
+	}
+}
+
+// WithClientCertificatePEM sets the PEM encoded certificate and key used for
+// mutual TLS.
+func WithClientCertificatePEM(cert, key []byte) ExporterOption {
+	return func(o *Options) {
+		o.ClientCertPEM = cert
+		o.ClientKeyPEM = key
+	}
+}
+
+// WithMinTLSVersion sets the minimum TLS version, such as tls.VersionTLS12.
+func WithMinTLSVersion(version uint16) ExporterOption {
+	return func(o *Options) {
+		o.MinTLSVersion = version
+	}
+}
+
+// WithProxy sends requests through the proxy at proxyURL. By default the
+// proxy is read from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+// variables.
+func WithProxy(proxyURL string) ExporterOption {
+	return func(o *Options) {
+		o.ProxyURL = proxyURL
+	}
+}
+
+// WithTimeouts sets the timeouts for dialing the forwarder, completing the
+// TLS handshake and the whole request. The defaults are 30 seconds, 10
+// seconds and 30 seconds. A request never outlives the retry deadline.
+func WithTimeouts(dial, tlsHandshake, request time.Duration) ExporterOption {
+	return func(o *Options) {
+		o.DialTimeout = dial
+		o.TLSHandshakeTimeout = tlsHandshake
+		o.RequestTimeout = request
+	}
+}
+
+// WithIdleConnections sets how many idle keep-alive connections are kept in
+// total and per host, and how long they are kept. The defaults are 10, 2 and
+// 90 seconds.
+func WithIdleConnections(max, maxPerHost int, timeout time.Duration) ExporterOption {
+	return func(o *Options) {
+		o.MaxIdleConns = max
+		o.MaxIdleConnsPerHost = maxPerHost
+		o.IdleConnTimeout = timeout
+	}
+}
+
+// WithHttpClient sends requests with client instead of a client built from
+// the TLS, proxy, timeout and connection options, which are then ignored.
+func WithHttpClient(client HttpClient) ExporterOption {
+	return func(o *Options) {
+		o.HttpClient = client
+	}
+}
+
+// WithRequestHook sets a function that is called with every request just
+// before it is sent, for example to add headers or sign it. The request is
+// not sent if the hook returns an error.
+func WithRequestHook(hook func(*http.Request) error) ExporterOption {
+	return func(o *Options) {
+		o.RequestHook = hook
+	}
+}
+
+// WithRetryMaxAttempts sets the number of times a batch is sent before it is
+// dropped. The default is 1, which disables retries.
+func WithRetryMaxAttempts(attempts int) ExporterOption {
+	return func(o *Options) {
+		o.RetryMaxAttempts = attempts
+	}
+}
+
+// WithRetryBackoff sets the initial and maximum backoff between attempts.
+// The backoff doubles after each attempt and is jittered.
+func WithRetryBackoff(initial, max time.Duration) ExporterOption {
+	return func(o *Options) {
+		o.RetryInitialBackoff = initial
+		o.RetryMaxBackoff = max
+	}
+}
+
+// WithRetryDeadline sets how long a batch may spend being sent, including
+// retries. The deadline never exceeds the frequency, which is the default.
+func WithRetryDeadline(d time.Duration) ExporterOption {
+	return func(o *Options) {
+		o.RetryDeadline = d
+	}
+}
+
+// WithSpool persists batches that could not be sent in dir, keeping at most
+// maxBytes of the newest data. Spooled batches are replayed, oldest first,
+// after the next successful send, for at most the retry deadline per
+// interval. Batches the forwarder rejects for a reason other than a server
+// error or rate limiting are dropped, and batches the circuit breaker rejects
+// are spooled as well. A maxBytes of 0 uses a 10MB cap.
+func WithSpool(dir string, maxBytes int64) ExporterOption {
+	return func(o *Options) {
+		o.SpoolDirectory = dir
+		o.SpoolMaxBytes = maxBytes
+	}
+}
+
+// WithBacklog keeps up to size batches that could not be sent in memory and
+// sends them after the next successful send, either one request per batch or
+// merged into a single request. It is ignored when a spool is configured.
+// Transporters that do not send timestamps, such as StatsD, skip the gauges
+// of a batch that are older than the ones already sent. The backlog depth and the number of batches dropped because it was full
+// are exported as pcfmetrics.backlog.depth and pcfmetrics.backlog.dropped.
+func WithBacklog(size int, merge bool) ExporterOption {
+	return func(o *Options) {
+		o.BacklogSize = size
+		o.MergeBacklog = merge
+	}
+}
+
+// WithCompression compresses request bodies of at least minBytes with the
+// given encoding, either CompressionGzip or CompressionZstd. Smaller bodies
+// are sent uncompressed. A minBytes of 0 uses a 1KB threshold.
+func WithCompression(encoding string, minBytes int) ExporterOption {
+	return func(o *Options) {
+		o.Compression = encoding
+		o.CompressionThreshold = minBytes
+	}
+}
+
+// WithMaxPayloadBytes splits batches whose JSON payload would be larger than
+// n bytes into several requests. The limit applies before compression.
+func WithMaxPayloadBytes(n int) ExporterOption {
+	return func(o *Options) {
+		o.MaxPayloadBytes = n
+	}
+}
+
+// WithMaxPointsPerRequest splits batches with more than n data points into
+// several requests. The data points of one metric, such as the fields of a
+// timer, are always sent together.
+func WithMaxPointsPerRequest(n int) ExporterOption {
+	return func(o *Options) {
+		o.MaxPointsPerRequest = n
+	}
+}
+
+// WithCircuitBreaker stops sending after failureThreshold consecutive failed
+// batches. Once openTimeout has passed, batches are sent again until
+// halfOpenSuccesses of them succeed, or one fails and the breaker opens
+// again. An openTimeout of 0 uses five times the frequency.
+func WithCircuitBreaker(failureThreshold int, openTimeout time.Duration, halfOpenSuccesses int) ExporterOption {
+	return func(o *Options) {
+		o.CircuitFailureThreshold = failureThreshold
+		o.CircuitOpenTimeout = openTimeout
+		o.CircuitHalfOpenSuccesses = halfOpenSuccesses
+	}
+}
+
+// WithCircuitStateChange sets a function that is called whenever the circuit
+// breaker changes state. The default logs the change.
+func WithCircuitStateChange(f func(from, to CircuitState)) ExporterOption {
+	return func(o *Options) {
+		o.OnCircuitStateChange = f
+	}
+}
+
+// WithErrorHandler sets a function that is called with the error of every
+// batch that could not be sent, such as a ForwarderError that errors.As can
+// match against AuthenticationError or RateLimitError, or ErrCircuitOpen. It
+// is called from the exporter's go-routine. The default logs the errors other
+// than ErrCircuitOpen.
+func WithErrorHandler(f func(error)) ExporterOption {
+	return func(o *Options) {
+		o.OnError = f
+	}
+}
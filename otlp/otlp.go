@@ -0,0 +1,303 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package otlp sends go-metrics data points to an OpenTelemetry collector
+// over OTLP/HTTP. It is a separate package so that applications that do not
+// use it do not depend on the OTLP protos.
+package otlp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+)
+
+const (
+	Protobuf = "protobuf"
+	JSON     = "json"
+)
+
+const (
+	scopeName             = "github.com/pivotal-cf/go-metrics-pcf"
+	defaultRequestTimeout = 30 * time.Second
+	maxDrainBytes         = 4 * 1024 * 1024
+)
+
+// Options configures a Transporter.
+type Options struct {
+	// Url is the OTLP/HTTP metrics endpoint, such as
+	// http://localhost:4318/v1/metrics.
+	Url string
+
+	// Encoding is either Protobuf or JSON. It defaults to Protobuf.
+	Encoding string
+
+	// Headers are added to every request, for example to authenticate with
+	// the collector.
+	Headers map[string]string
+
+	// Compression is the Content-Encoding of the requests, such as
+	// pcfmetrics.CompressionGzip. Requests are not compressed by default.
+	Compression string
+
+	// ResourceAttributes are added to the attributes of the resource.
+	ResourceAttributes map[string]string
+
+	// HttpClient defaults to a client with a 30 second timeout.
+	HttpClient pcfmetrics.HttpClient
+}
+
+// Transporter sends data points to an OpenTelemetry collector as an OTLP
+// ExportMetricsServiceRequest. Counters are sent as cumulative monotonic
+// sums, gauges as gauges, and timers and histograms as summaries.
+type Transporter struct {
+	options   Options
+	client    pcfmetrics.HttpClient
+	resource  *resourcepb.Resource
+	startTime uint64
+}
+
+// NewTransporter returns a transporter for
+// pcfmetrics.StartExporterWithTransporter that sends metrics to an
+// OpenTelemetry collector over HTTP. The app GUID, instance ID and instance
+// index in opts become resource attributes, and default to the values in
+// the environment as they do for pcfmetrics.StartExporter.
+func NewTransporter(otlpOptions Options, opts ...pcfmetrics.ExporterOption) *Transporter {
+	if otlpOptions.Encoding == "" {
+		otlpOptions.Encoding = Protobuf
+	}
+
+	client := otlpOptions.HttpClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultRequestTimeout}
+	}
+
+	return &Transporter{
+		options:   otlpOptions,
+		client:    client,
+		resource:  newResource(pcfmetrics.InstanceOptions(opts...), otlpOptions.ResourceAttributes),
+		startTime: uint64(time.Now().UnixNano()),
+	}
+}
+
+func (t *Transporter) SendMetrics(points []*pcfmetrics.DataPoint) error {
+	request := &colmetricpb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: t.resource,
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: scopeName},
+				Metrics: t.convertPoints(points),
+			}},
+		}},
+	}
+
+	req, err := t.createRequest(request)
+	if err != nil {
+		return err
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return pcfmetrics.NewForwarderError(res)
+	}
+
+	return nil
+}
+
+func (t *Transporter) createRequest(request *colmetricpb.ExportMetricsServiceRequest) (*http.Request, error) {
+	var body []byte
+	var err error
+	contentType := "application/x-protobuf"
+	if t.options.Encoding == JSON {
+		body, err = protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(request)
+		contentType = "application/json"
+	} else {
+		body, err = proto.Marshal(request)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t.options.Compression != "" {
+		body, err = pcfmetrics.Compress(t.options.Compression, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.options.Url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	if t.options.Compression != "" {
+		req.Header.Set("Content-Encoding", t.options.Compression)
+	}
+	for key, value := range t.options.Headers {
+		req.Header.Set(key, value)
+	}
+
+	return req, nil
+}
+
+func (t *Transporter) convertPoints(points []*pcfmetrics.DataPoint) []*metricspb.Metric {
+	var result []*metricspb.Metric
+
+	for _, group := range pcfmetrics.GroupPoints(points) {
+		summary, rest := pcfmetrics.Summarize(group)
+		if summary != nil {
+			result = append(result, t.convertSummary(summary))
+		}
+
+		for _, point := range rest {
+			if math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+				continue
+			}
+			result = append(result, t.convertPoint(point))
+		}
+	}
+
+	return result
+}
+
+func (t *Transporter) convertSummary(summary *pcfmetrics.Summary) *metricspb.Metric {
+	dataPoint := &metricspb.SummaryDataPoint{
+		StartTimeUnixNano: t.startTime,
+		TimeUnixNano:      timeUnixNano(summary.Timestamp),
+		Count:             uint64(summary.Count),
+		Sum:               summary.Sum,
+	}
+
+	for _, q := range summary.Quantiles {
+		dataPoint.QuantileValues = append(dataPoint.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+			Quantile: q.Quantile,
+			Value:    q.Value,
+		})
+	}
+
+	return &metricspb.Metric{
+		Name: summary.Name,
+		Unit: unitName(summary.Unit),
+		Data: &metricspb.Metric_Summary{
+			Summary: &metricspb.Summary{DataPoints: []*metricspb.SummaryDataPoint{dataPoint}},
+		},
+	}
+}
+
+func (t *Transporter) convertPoint(point *pcfmetrics.DataPoint) *metricspb.Metric {
+	metric := &metricspb.Metric{
+		Name: point.Name,
+		Unit: unitName(point.Unit),
+	}
+
+	if point.Type == "counter" {
+		metric.Data = &metricspb.Metric_Sum{
+			Sum: &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+				DataPoints: []*metricspb.NumberDataPoint{{
+					StartTimeUnixNano: t.startTime,
+					TimeUnixNano:      timeUnixNano(point.Timestamp),
+					Value:             &metricspb.NumberDataPoint_AsInt{AsInt: int64(point.Value)},
+				}},
+			},
+		}
+		return metric
+	}
+
+	metric.Data = &metricspb.Metric_Gauge{
+		Gauge: &metricspb.Gauge{
+			DataPoints: []*metricspb.NumberDataPoint{{
+				TimeUnixNano: timeUnixNano(point.Timestamp),
+				Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: point.Value},
+			}},
+		},
+	}
+	return metric
+}
+
+func newResource(options *pcfmetrics.Options, extra map[string]string) *resourcepb.Resource {
+	attributes := map[string]string{
+		"cloudfoundry.app.id":          options.AppGuid,
+		"cloudfoundry.app.instance.id": options.InstanceIndex,
+		"service.instance.id":          options.InstanceId,
+	}
+	for key, value := range extra {
+		attributes[key] = value
+	}
+
+	keys := make([]string, 0, len(attributes))
+	for key, value := range attributes {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	resource := &resourcepb.Resource{}
+	for _, key := range keys {
+		resource.Attributes = append(resource.Attributes, &commonpb.KeyValue{
+			Key:   key,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: attributes[key]}},
+		})
+	}
+
+	return resource
+}
+
+// unitName converts the time unit names of the data points to UCUM units.
+func unitName(unit string) string {
+	switch unit {
+	case "seconds":
+		return "s"
+	case "milliseconds":
+		return "ms"
+	case "microseconds":
+		return "us"
+	case "nanoseconds":
+		return "ns"
+	default:
+		return unit
+	}
+}
+
+func timeUnixNano(timestampMillis int64) uint64 {
+	return uint64(timestampMillis) * uint64(time.Millisecond)
+}
+
+// drainAndClose reads what is left of a response body so that the connection
+// can be reused for the next request.
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, io.LimitReader(body, maxDrainBytes))
+	body.Close()
+}
@@ -0,0 +1,172 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+	metricFakes "github.com/pivotal-cf/go-metrics-pcf/go-metrics-pcffakes"
+	"github.com/pivotal-cf/go-metrics-pcf/otlp"
+	"github.com/rcrowley/go-metrics"
+)
+
+var _ = Describe("OTLP transporter", func() {
+	var (
+		server   *httptest.Server
+		requests chan *http.Request
+		bodies   chan []byte
+		registry metrics.Registry
+	)
+
+	BeforeEach(func() {
+		requests = make(chan *http.Request, 100)
+		bodies = make(chan []byte, 100)
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			requests <- req
+			bodies <- body
+		}))
+
+		registry = metrics.NewRegistry()
+
+		counter := metrics.NewCounter()
+		counter.Inc(6)
+		registry.Register("test-counter", counter)
+
+		gauge := metrics.NewGaugeFloat64()
+		gauge.Update(32.2)
+		registry.Register("test-gauge", gauge)
+
+		fakeTimer := new(metricFakes.FakeTimer)
+		fakeTimer.SnapshotReturns(fakeTimer)
+		fakeTimer.CountReturns(3)
+		fakeTimer.SumReturns(7 * int64(time.Millisecond))
+		fakeTimer.PercentilesReturns([]float64{
+			11 * float64(time.Millisecond),
+			12 * float64(time.Millisecond),
+			13 * float64(time.Millisecond),
+			14 * float64(time.Millisecond),
+			15 * float64(time.Millisecond),
+		})
+		registry.Register("test-timer", fakeTimer)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	var findMetric = func(request *colmetricpb.ExportMetricsServiceRequest, name string) *metricspb.Metric {
+		for _, metric := range request.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+			if metric.Name == name {
+				return metric
+			}
+		}
+		return nil
+	}
+
+	It("sends counters as sums, gauges as gauges and timers as summaries", func() {
+		transport := otlp.NewTransporter(
+			otlp.Options{
+				Url:     server.URL + "/v1/metrics",
+				Headers: map[string]string{"Api-Key": "some-key"},
+			},
+			pcfmetrics.WithAppGuid("some-app-guid"),
+			pcfmetrics.WithInstanceId("some-instance-guid"),
+			pcfmetrics.WithInstanceIndex("1"),
+		)
+
+		stop := pcfmetrics.StartExporterWithTransporter(registry, transport, pcfmetrics.WithFrequency(10*time.Millisecond))
+		defer stop()
+
+		var req *http.Request
+		Eventually(requests).Should(Receive(&req))
+		Expect(req.URL.Path).To(Equal("/v1/metrics"))
+		Expect(req.Header.Get("Content-Type")).To(Equal("application/x-protobuf"))
+		Expect(req.Header.Get("Api-Key")).To(Equal("some-key"))
+
+		var body []byte
+		Eventually(bodies).Should(Receive(&body))
+		request := &colmetricpb.ExportMetricsServiceRequest{}
+		Expect(proto.Unmarshal(body, request)).To(Succeed())
+
+		attributes := map[string]string{}
+		for _, attribute := range request.ResourceMetrics[0].Resource.Attributes {
+			attributes[attribute.Key] = attribute.Value.GetStringValue()
+		}
+		Expect(attributes).To(Equal(map[string]string{
+			"cloudfoundry.app.id":          "some-app-guid",
+			"cloudfoundry.app.instance.id": "1",
+			"service.instance.id":          "some-instance-guid",
+		}))
+
+		sum := findMetric(request, "test-counter").GetSum()
+		Expect(sum.IsMonotonic).To(BeTrue())
+		Expect(sum.AggregationTemporality).To(Equal(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE))
+		Expect(sum.DataPoints[0].GetAsInt()).To(Equal(int64(6)))
+		Expect(sum.DataPoints[0].StartTimeUnixNano).To(BeNumerically("<=", sum.DataPoints[0].TimeUnixNano))
+
+		gauge := findMetric(request, "test-gauge").GetGauge()
+		Expect(gauge.DataPoints[0].GetAsDouble()).To(Equal(32.2))
+
+		timer := findMetric(request, "test-timer.duration")
+		Expect(timer.Unit).To(Equal("ms"))
+		summary := timer.GetSummary().DataPoints[0]
+		Expect(summary.Count).To(Equal(uint64(3)))
+		Expect(summary.Sum).To(Equal(7.0))
+		Expect(summary.QuantileValues).To(HaveLen(5))
+		Expect(summary.QuantileValues[4].Quantile).To(Equal(0.999))
+		Expect(summary.QuantileValues[4].Value).To(Equal(15.0))
+
+		Expect(findMetric(request, "test-timer.duration.mean").GetGauge()).ToNot(BeNil())
+		Expect(findMetric(request, "test-timer.count")).To(BeNil())
+	})
+
+	It("sends JSON with enums as numbers", func() {
+		transport := otlp.NewTransporter(otlp.Options{
+			Url:      server.URL + "/v1/metrics",
+			Encoding: otlp.JSON,
+		})
+
+		err := transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-counter", Metric: "test-counter", Type: "counter", Value: 6, Timestamp: 1500000000123},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var req *http.Request
+		Eventually(requests).Should(Receive(&req))
+		Expect(req.Header.Get("Content-Type")).To(Equal("application/json"))
+
+		var body []byte
+		Eventually(bodies).Should(Receive(&body))
+		Expect(string(body)).To(MatchRegexp(`"aggregationTemporality":\s*2`))
+
+		request := &colmetricpb.ExportMetricsServiceRequest{}
+		Expect(protojson.Unmarshal(body, request)).To(Succeed())
+		Expect(findMetric(request, "test-counter").GetSum().DataPoints[0].TimeUnixNano).To(Equal(uint64(1500000000123000000)))
+	})
+})
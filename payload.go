@@ -18,7 +18,7 @@ type metricForwarderPayload struct {
 	Applications []*metricForwarderApplication `json:"applications"`
 }
 
-func newMetricForwarderPayload(points []*dataPoint, options *Options) *metricForwarderPayload {
+func newMetricForwarderPayload(points []*DataPoint, options *Options) *metricForwarderPayload {
 	return &metricForwarderPayload{
 		Applications: []*metricForwarderApplication{
 			newMetricForwarderApplication(points, options),
@@ -31,7 +31,7 @@ type metricForwarderApplication struct {
 	Instances []*metricForwarderInstance `json:"instances"`
 }
 
-func newMetricForwarderApplication(points []*dataPoint, options *Options) *metricForwarderApplication {
+func newMetricForwarderApplication(points []*DataPoint, options *Options) *metricForwarderApplication {
 	return &metricForwarderApplication{
 		Id: options.AppGuid,
 		Instances: []*metricForwarderInstance{
@@ -43,10 +43,10 @@ func newMetricForwarderApplication(points []*dataPoint, options *Options) *metri
 type metricForwarderInstance struct {
 	Id      string `json:"id"`
 	Index   string `json:"index"`
-	Metrics []*dataPoint `json:"metrics"`
+	Metrics []*DataPoint `json:"metrics"`
 }
 
-func newMetricForwarderInstance(points []*dataPoint, options *Options) *metricForwarderInstance {
+func newMetricForwarderInstance(points []*DataPoint, options *Options) *metricForwarderInstance {
 	return &metricForwarderInstance{
 		Id:      options.InstanceId,
 		Index:   options.InstanceIndex,
@@ -0,0 +1,223 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricFamily is a metric in the Prometheus data model. Timers and
+// histograms become summaries, and everything else a counter or gauge.
+type metricFamily struct {
+	name    string
+	typ     string
+	unit    string
+	metric  string
+	samples []metricSample
+}
+
+type metricSample struct {
+	suffix string
+	labels string
+	value  float64
+}
+
+type prometheusHandler struct {
+	registry metrics.Registry
+	exporter *exporter
+
+	mu      sync.Mutex
+	created map[string]time.Time
+}
+
+// NewPrometheusHandler returns an http.Handler that renders the registry in
+// the Prometheus text exposition format, or in the OpenMetrics format when
+// the Accept header of the request asks for it. Timers and histograms are
+// rendered as summaries with a quantile label, and durations use the
+// TimeUnit from the options, which is milliseconds by default.
+func NewPrometheusHandler(registry metrics.Registry, opts ...ExporterOption) http.Handler {
+	options := newOptions(opts)
+
+	return &prometheusHandler{
+		registry: registry,
+		exporter: newExporter(nil, options.TimeUnit),
+		created:  make(map[string]time.Time),
+	}
+}
+
+func (h *prometheusHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	families := buildFamilies(h.exporter.assembleDataPoints(h.registry))
+	created := h.createdTimes(families)
+
+	if acceptsOpenMetrics(req.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", openMetricsContentType)
+		writeOpenMetricsText(w, families, created)
+		return
+	}
+
+	w.Header().Set("Content-Type", prometheusContentType)
+	writePrometheusText(w, families)
+}
+
+// buildFamilies converts data points to metric families sorted by name.
+func buildFamilies(points []*DataPoint) []*metricFamily {
+	var families []*metricFamily
+	seen := make(map[string]bool)
+
+	add := func(family *metricFamily, metric string) {
+		if seen[family.name] {
+			return
+		}
+		seen[family.name] = true
+		family.metric = metric
+		families = append(families, family)
+	}
+
+	for _, group := range GroupPoints(points) {
+		summary, rest := Summarize(group)
+		if summary != nil {
+			add(newSummaryFamily(summary), group[0].Metric)
+		}
+
+		for _, point := range rest {
+			add(newPointFamily(point), point.Metric)
+		}
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	return families
+}
+
+// createdTimes returns the time each metric was first scraped, recording the
+// current time for metrics that have not been seen before.
+func (h *prometheusHandler) createdTimes(families []*metricFamily) map[string]time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	created := make(map[string]time.Time, len(families))
+	for _, family := range families {
+		first, ok := h.created[family.metric]
+		if !ok {
+			first = now
+			h.created[family.metric] = first
+		}
+		created[family.metric] = first
+	}
+
+	return created
+}
+
+func newSummaryFamily(summary *Summary) *metricFamily {
+	family := &metricFamily{
+		name: prometheusName(summary.Name, summary.Unit),
+		typ:  "summary",
+		unit: summary.Unit,
+	}
+
+	for _, q := range summary.Quantiles {
+		family.samples = append(family.samples, metricSample{
+			labels: fmt.Sprintf(`quantile="%s"`, formatValue(q.Quantile)),
+			value:  q.Value,
+		})
+	}
+
+	family.samples = append(family.samples,
+		metricSample{suffix: "_sum", value: summary.Sum},
+		metricSample{suffix: "_count", value: summary.Count},
+	)
+
+	return family
+}
+
+func newPointFamily(point *DataPoint) *metricFamily {
+	return &metricFamily{
+		name:    prometheusName(point.Name, point.Unit),
+		typ:     point.Type,
+		unit:    point.Unit,
+		samples: []metricSample{{value: point.Value}},
+	}
+}
+
+// prometheusName replaces the characters that are not allowed in Prometheus
+// metric names with underscores and appends the unit.
+func prometheusName(name, unit string) string {
+	if unit != "" && !strings.HasSuffix(name, "."+unit) {
+		name = joinNameParts(name, unit)
+	}
+
+	sanitized := []rune(name)
+	for i, r := range sanitized {
+		valid := r == '_' || r == ':' ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(r >= '0' && r <= '9' && i > 0)
+		if !valid {
+			sanitized[i] = '_'
+		}
+	}
+
+	return string(sanitized)
+}
+
+func writePrometheusText(w io.Writer, families []*metricFamily) error {
+	buf := bufio.NewWriter(w)
+
+	for _, family := range families {
+		fmt.Fprintf(buf, "# TYPE %s %s\n", family.name, family.typ)
+		for _, sample := range family.samples {
+			writeSample(buf, family.name, sample)
+		}
+	}
+
+	return buf.Flush()
+}
+
+func writeSample(w io.Writer, name string, sample metricSample) {
+	if sample.labels == "" {
+		fmt.Fprintf(w, "%s%s %s\n", name, sample.suffix, formatValue(sample.value))
+		return
+	}
+
+	fmt.Fprintf(w, "%s%s{%s} %s\n", name, sample.suffix, sample.labels, formatValue(sample.value))
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
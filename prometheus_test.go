@@ -0,0 +1,196 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+	metricFakes "github.com/pivotal-cf/go-metrics-pcf/go-metrics-pcffakes"
+	"github.com/rcrowley/go-metrics"
+)
+
+var _ = Describe("Prometheus handler", func() {
+	var registry metrics.Registry
+
+	var scrape = func(handler http.Handler) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	BeforeEach(func() {
+		registry = metrics.NewRegistry()
+	})
+
+	It("renders counters and gauges", func() {
+		counter := metrics.NewCounter()
+		counter.Inc(6)
+		registry.Register("test-counter", counter)
+
+		gauge := metrics.NewGaugeFloat64()
+		gauge.Update(32.2)
+		registry.Register("test.gauge", gauge)
+
+		res := scrape(pcfmetrics.NewPrometheusHandler(registry))
+
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Header().Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+		Expect(res.Body.String()).To(ContainSubstring("# TYPE test_counter counter\ntest_counter 6\n"))
+		Expect(res.Body.String()).To(ContainSubstring("# TYPE test_gauge gauge\ntest_gauge 32.2\n"))
+	})
+
+	It("renders timers as summaries with quantile labels", func() {
+		fakeTimer := new(metricFakes.FakeTimer)
+		fakeTimer.SnapshotReturns(fakeTimer)
+		fakeTimer.CountReturns(3)
+		fakeTimer.SumReturns(7 * int64(time.Millisecond))
+		fakeTimer.MeanReturns(5 * float64(time.Millisecond))
+		fakeTimer.PercentilesReturns([]float64{
+			11 * float64(time.Millisecond),
+			12 * float64(time.Millisecond),
+			13 * float64(time.Millisecond),
+			14 * float64(time.Millisecond),
+			15 * float64(time.Millisecond),
+		})
+		registry.Register("test-timer", fakeTimer)
+
+		body := scrape(pcfmetrics.NewPrometheusHandler(registry)).Body.String()
+
+		Expect(body).To(ContainSubstring(
+			"# TYPE test_timer_duration_milliseconds summary\n" +
+				"test_timer_duration_milliseconds{quantile=\"0.75\"} 11\n" +
+				"test_timer_duration_milliseconds{quantile=\"0.95\"} 12\n" +
+				"test_timer_duration_milliseconds{quantile=\"0.98\"} 13\n" +
+				"test_timer_duration_milliseconds{quantile=\"0.99\"} 14\n" +
+				"test_timer_duration_milliseconds{quantile=\"0.999\"} 15\n" +
+				"test_timer_duration_milliseconds_sum 7\n" +
+				"test_timer_duration_milliseconds_count 3\n",
+		))
+		Expect(body).To(ContainSubstring("test_timer_duration_mean_milliseconds 5\n"))
+		Expect(body).To(ContainSubstring("# TYPE test_timer_rate_1_minute gauge\n"))
+		Expect(body).ToNot(ContainSubstring("test_timer_count"))
+	})
+
+	It("renders histograms as summaries", func() {
+		fakeHistogram := new(metricFakes.FakeHistogram)
+		fakeHistogram.SnapshotReturns(fakeHistogram)
+		fakeHistogram.CountReturns(1)
+		fakeHistogram.SumReturns(4)
+		fakeHistogram.PercentilesReturns([]float64{8, 9, 10, 11, 12})
+		registry.Register("test-histogram", fakeHistogram)
+
+		body := scrape(pcfmetrics.NewPrometheusHandler(registry)).Body.String()
+
+		Expect(body).To(ContainSubstring("# TYPE test_histogram summary\n"))
+		Expect(body).To(ContainSubstring("test_histogram{quantile=\"0.999\"} 12\n"))
+		Expect(body).To(ContainSubstring("test_histogram_sum 4\n"))
+		Expect(body).To(ContainSubstring("test_histogram_count 1\n"))
+	})
+
+	It("uses the time unit from the options", func() {
+		fakeTimer := new(metricFakes.FakeTimer)
+		fakeTimer.SnapshotReturns(fakeTimer)
+		fakeTimer.SumReturns(7 * int64(time.Millisecond))
+		fakeTimer.PercentilesReturns([]float64{0, 0, 0, 0, 0})
+		registry.Register("test-timer", fakeTimer)
+
+		body := scrape(pcfmetrics.NewPrometheusHandler(registry, pcfmetrics.WithTimeUnit(time.Second))).Body.String()
+
+		Expect(body).To(ContainSubstring("test_timer_duration_seconds_sum 0.007\n"))
+	})
+	Context("when the scraper accepts OpenMetrics", func() {
+		var scrapeOpenMetrics = func(handler http.Handler) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5")
+			handler.ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		It("renders counters with the _total suffix and a _created timestamp", func() {
+			counter := metrics.NewCounter()
+			counter.Inc(6)
+			registry.Register("test-counter", counter)
+
+			before := float64(time.Now().Unix())
+			res := scrapeOpenMetrics(pcfmetrics.NewPrometheusHandler(registry))
+
+			Expect(res.Header().Get("Content-Type")).To(HavePrefix("application/openmetrics-text; version=1.0.0"))
+
+			body := res.Body.String()
+			Expect(body).To(ContainSubstring("# TYPE test_counter counter\ntest_counter_total 6\ntest_counter_created "))
+			Expect(body).To(HaveSuffix("# EOF\n"))
+
+			var created float64
+			_, err := fmt.Sscanf(body[strings.Index(body, "test_counter_created "):], "test_counter_created %g", &created)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(created).To(BeNumerically(">=", before))
+		})
+
+		It("renders units and keeps the first _created timestamp", func() {
+			fakeTimer := new(metricFakes.FakeTimer)
+			fakeTimer.SnapshotReturns(fakeTimer)
+			fakeTimer.PercentilesReturns([]float64{0, 0, 0, 0, 0})
+			registry.Register("test-timer", fakeTimer)
+
+			handler := pcfmetrics.NewPrometheusHandler(registry)
+			first := scrapeOpenMetrics(handler).Body.String()
+			time.Sleep(10 * time.Millisecond)
+			second := scrapeOpenMetrics(handler).Body.String()
+
+			Expect(first).To(ContainSubstring(
+				"# TYPE test_timer_duration_milliseconds summary\n" +
+					"# UNIT test_timer_duration_milliseconds milliseconds\n",
+			))
+			Expect(first).To(ContainSubstring("# UNIT test_timer_duration_mean_milliseconds milliseconds\n"))
+
+			createdLine := regexp.MustCompile(`test_timer_duration_milliseconds_created \S+\n`)
+			Expect(createdLine.FindString(first)).ToNot(BeEmpty())
+			Expect(createdLine.FindString(second)).To(Equal(createdLine.FindString(first)))
+		})
+
+		It("does not add _created to gauges", func() {
+			gauge := metrics.NewGauge()
+			gauge.Update(4)
+			registry.Register("test-gauge", gauge)
+
+			body := scrapeOpenMetrics(pcfmetrics.NewPrometheusHandler(registry)).Body.String()
+
+			Expect(body).To(Equal("# TYPE test_gauge gauge\ntest_gauge 4\n# EOF\n"))
+		})
+	})
+
+	It("renders Prometheus text when OpenMetrics is not acceptable", func() {
+		registry.Register("test-counter", metrics.NewCounter())
+
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", "application/openmetrics-text;q=0,text/plain")
+		pcfmetrics.NewPrometheusHandler(registry).ServeHTTP(recorder, req)
+
+		Expect(recorder.Header().Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+		Expect(recorder.Body.String()).ToNot(ContainSubstring("# EOF"))
+	})
+})
@@ -0,0 +1,153 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryAfter returns how long the forwarder asked us to wait, from either
+// the Retry-After header, which is a number of seconds or an HTTP date, or
+// the RateLimit-Reset and X-RateLimit-Reset headers. It returns 0 when none
+// of them are set.
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	if value := header.Get("Retry-After"); value != "" {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err == nil {
+			return nonNegative(time.Duration(seconds) * time.Second)
+		}
+
+		date, err := http.ParseTime(value)
+		if err == nil {
+			return nonNegative(date.Sub(now))
+		}
+	}
+
+	return rateLimitReset(header, now)
+}
+
+// rateLimitReset reads RateLimit-Reset, which is a number of seconds, or
+// X-RateLimit-Reset, which is either a number of seconds or a unix time.
+func rateLimitReset(header http.Header, now time.Time) time.Duration {
+	for _, name := range []string{"RateLimit-Reset", "X-RateLimit-Reset"} {
+		seconds, err := strconv.ParseInt(header.Get(name), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if seconds > now.Unix()/2 {
+			return nonNegative(time.Unix(seconds, 0).Sub(now))
+		}
+
+		return nonNegative(time.Duration(seconds) * time.Second)
+	}
+
+	return 0
+}
+
+// rateLimitExhausted reports whether a successful response says there are no
+// requests left in the current rate limit window.
+func rateLimitExhausted(header http.Header) bool {
+	for _, name := range []string{"RateLimit-Remaining", "X-RateLimit-Remaining"} {
+		if header.Get(name) == "0" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+
+	return d
+}
+
+func (h *httpTransporter) paused() bool {
+	return time.Now().Before(h.pausedUntil)
+}
+
+// pause stops sends for d, or for one interval when the forwarder did not
+// say how long to wait.
+func (h *httpTransporter) pause(d time.Duration) {
+	if d <= 0 {
+		d = h.options.Frequency
+	}
+
+	until := time.Now().Add(d)
+	if until.After(h.pausedUntil) {
+		log.Printf("Metrics forwarder is rate limiting; pausing sends for %s", d)
+		h.pausedUntil = until
+	}
+}
+
+// deferPoints keeps points that could not be sent because of rate limiting
+// so they are merged into the next send. Only the latest value of each data
+// point is kept, which loses nothing since counters are cumulative, and keeps
+// the deferred points from growing for as long as the forwarder throttles.
+func (h *httpTransporter) deferPoints(points []*DataPoint) {
+	if h.deferredIndex == nil {
+		h.deferredIndex = make(map[string]int)
+	}
+
+	for _, point := range points {
+		i, ok := h.deferredIndex[point.Name]
+		if !ok {
+			h.deferredIndex[point.Name] = len(h.deferred)
+			h.deferred = append(h.deferred, point)
+			continue
+		}
+
+		if point.Timestamp >= h.deferred[i].Timestamp {
+			h.deferred[i] = point
+		}
+	}
+}
+
+// takeDeferred returns the points held back by rate limiting and forgets
+// them.
+func (h *httpTransporter) takeDeferred() []*DataPoint {
+	deferred := h.deferred
+	h.deferred = nil
+	h.deferredIndex = nil
+
+	return deferred
+}
+
+// keepDeferred defers the unsent points that had been deferred before, which
+// the exporter already counts as sent, again when they cannot be spooled. It
+// returns the rest of unsent.
+func (h *httpTransporter) keepDeferred(unsent []*DataPoint, wasDeferred map[*DataPoint]bool) []*DataPoint {
+	if h.spool != nil || len(wasDeferred) == 0 {
+		return unsent
+	}
+
+	var rest, deferred []*DataPoint
+	for _, point := range unsent {
+		if wasDeferred[point] {
+			deferred = append(deferred, point)
+		} else {
+			rest = append(rest, point)
+		}
+	}
+
+	h.deferPoints(deferred)
+	return rest
+}
@@ -0,0 +1,162 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/pivotal-cf/go-metrics-pcf/internal/deltas"
+)
+
+const (
+	RegistrarJSON      = "json"
+	RegistrarDogStatsD = "dogstatsd"
+)
+
+// RegistrarOptions configures a RegistrarEmitter.
+type RegistrarOptions struct {
+	// Writer defaults to os.Stdout, which is where the Metric Registrar
+	// reads the app's logs from.
+	Writer io.Writer
+
+	// Format is either RegistrarJSON or RegistrarDogStatsD. It defaults to
+	// RegistrarJSON.
+	Format string
+
+	// Tags are added to every metric.
+	Tags map[string]string
+}
+
+// RegistrarEmitter writes data points as log lines in the formats the CF
+// Metric Registrar turns into metrics. Gauges are written with their value
+// and counters with the change since the last batch.
+type RegistrarEmitter struct {
+	options  RegistrarOptions
+	counters *deltas.Counters
+	tags     string
+}
+
+type registrarMetric struct {
+	Type  string            `json:"type"`
+	Name  string            `json:"name"`
+	Value *float64          `json:"value,omitempty"`
+	Delta *float64          `json:"delta,omitempty"`
+	Unit  string            `json:"unit,omitempty"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+// NewRegistrarEmitter returns a transporter for StartExporterWithTransporter
+// that writes metrics for the Metric Registrar.
+func NewRegistrarEmitter(options RegistrarOptions) *RegistrarEmitter {
+	if options.Writer == nil {
+		options.Writer = os.Stdout
+	}
+	if options.Format == "" {
+		options.Format = RegistrarJSON
+	}
+
+	return &RegistrarEmitter{
+		options:  options,
+		counters: deltas.New(),
+		tags:     formatStatsdTags(options.Tags),
+	}
+}
+
+// SendMetrics writes the batch with a single write, so that its lines are
+// not interleaved with other output.
+func (e *RegistrarEmitter) SendMetrics(points []*DataPoint) error {
+	var buf bytes.Buffer
+	counters := e.counters.Batch()
+	for _, point := range SortByTimestamp(points) {
+		if math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+			continue
+		}
+
+		value := point.Value
+		if point.Type == "counter" {
+			var ok bool
+			value, ok = counters.Delta(point.Name, point.Value, point.Timestamp)
+			if !ok {
+				continue
+			}
+
+			// The Registrar turns counters into Loggregator counter
+			// envelopes, whose deltas cannot be negative.
+			if value < 0 {
+				value = 0
+			}
+		} else if !counters.Current(point.Name, point.Timestamp) {
+			continue
+		}
+
+		var err error
+		if e.options.Format == RegistrarDogStatsD {
+			e.writeDogStatsD(&buf, point, value)
+		} else {
+			err = e.writeJSON(&buf, point, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	_, err := e.options.Writer.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	counters.Commit()
+
+	return nil
+}
+
+func (e *RegistrarEmitter) writeJSON(buf *bytes.Buffer, point *DataPoint, value float64) error {
+	metric := registrarMetric{
+		Type: point.Type,
+		Name: point.Name,
+		Tags: e.options.Tags,
+	}
+
+	if point.Type == "counter" {
+		metric.Delta = &value
+	} else {
+		metric.Type = "gauge"
+		metric.Value = &value
+		metric.Unit = point.Unit
+	}
+
+	return json.NewEncoder(buf).Encode(metric)
+}
+
+func (e *RegistrarEmitter) writeDogStatsD(buf *bytes.Buffer, point *DataPoint, value float64) {
+	statsdType := "g"
+	if point.Type == "counter" {
+		statsdType = "c"
+	}
+
+	buf.WriteString(statsdName("", point.Name))
+	buf.WriteByte(':')
+	buf.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	buf.WriteString("|" + statsdType + e.tags + "\n")
+}
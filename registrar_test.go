@@ -0,0 +1,115 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics_test
+
+import (
+	"bytes"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+)
+
+var _ = Describe("Metric Registrar emitter", func() {
+	var (
+		buf    *bytes.Buffer
+		points []*pcfmetrics.DataPoint
+	)
+
+	BeforeEach(func() {
+		buf = new(bytes.Buffer)
+		points = []*pcfmetrics.DataPoint{
+			{Name: "test-counter", Type: "counter", Value: 6},
+			{Name: "test-timer.duration.mean", Type: "gauge", Value: 5.5, Unit: "milliseconds"},
+		}
+	})
+
+	var lines = func() []string {
+		defer buf.Reset()
+		return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	}
+
+	It("writes gauges and counter deltas in the JSON format", func() {
+		emitter := pcfmetrics.NewRegistrarEmitter(pcfmetrics.RegistrarOptions{
+			Writer: buf,
+			Tags:   map[string]string{"env": "staging"},
+		})
+
+		Expect(emitter.SendMetrics(points)).To(Succeed())
+
+		written := lines()
+		Expect(written).To(HaveLen(2))
+		Expect(written[0]).To(MatchJSON(`{"type":"counter","name":"test-counter","delta":6,"tags":{"env":"staging"}}`))
+		Expect(written[1]).To(MatchJSON(`{"type":"gauge","name":"test-timer.duration.mean","value":5.5,"unit":"milliseconds","tags":{"env":"staging"}}`))
+
+		points[0].Value = 10
+		Expect(emitter.SendMetrics(points[:1])).To(Succeed())
+		Expect(lines()[0]).To(MatchJSON(`{"type":"counter","name":"test-counter","delta":4,"tags":{"env":"staging"}}`))
+	})
+
+	It("writes counters that went down as 0", func() {
+		emitter := pcfmetrics.NewRegistrarEmitter(pcfmetrics.RegistrarOptions{Writer: buf})
+
+		Expect(emitter.SendMetrics(points[:1])).To(Succeed())
+		Expect(lines()[0]).To(MatchJSON(`{"type":"counter","name":"test-counter","delta":6}`))
+
+		points[0].Value = 2
+		Expect(emitter.SendMetrics(points[:1])).To(Succeed())
+		Expect(lines()[0]).To(MatchJSON(`{"type":"counter","name":"test-counter","delta":0}`))
+
+		dogStatsD := pcfmetrics.NewRegistrarEmitter(pcfmetrics.RegistrarOptions{
+			Writer: buf,
+			Format: pcfmetrics.RegistrarDogStatsD,
+		})
+
+		points[0].Value = -3
+		Expect(dogStatsD.SendMetrics(points[:1])).To(Succeed())
+		Expect(lines()).To(Equal([]string{"test-counter:0|c"}))
+	})
+
+	It("skips gauges older than the last one sent", func() {
+		emitter := pcfmetrics.NewRegistrarEmitter(pcfmetrics.RegistrarOptions{Writer: buf})
+
+		Expect(emitter.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-gauge", Type: "gauge", Value: 3, Timestamp: 2000},
+		})).To(Succeed())
+		Expect(lines()).To(HaveLen(1))
+
+		Expect(emitter.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-gauge", Type: "gauge", Value: 1, Timestamp: 1000},
+		})).To(Succeed())
+		Expect(buf.Len()).To(BeZero())
+	})
+
+	It("writes the DogStatsD format with tags", func() {
+		emitter := pcfmetrics.NewRegistrarEmitter(pcfmetrics.RegistrarOptions{
+			Writer: buf,
+			Format: pcfmetrics.RegistrarDogStatsD,
+			Tags:   map[string]string{"env": "staging", "team": "metrics"},
+		})
+
+		Expect(emitter.SendMetrics(points)).To(Succeed())
+
+		Expect(lines()).To(Equal([]string{
+			"test-counter:6|c|#env:staging,team:metrics",
+			"test-timer.duration.mean:5.5|g|#env:staging,team:metrics",
+		}))
+
+		Expect(emitter.SendMetrics(points[:1])).To(Succeed())
+		Expect(lines()).To(Equal([]string{"test-counter:0|c|#env:staging,team:metrics"}))
+	})
+})
@@ -0,0 +1,74 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	defaultRetryInitialBackoff = 500 * time.Millisecond
+	defaultRetryMaxBackoff     = 10 * time.Second
+)
+
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	attempt uint
+}
+
+func newBackoff(initial, max time.Duration) *backoff {
+	return &backoff{
+		initial: initial,
+		max:     max,
+	}
+}
+
+// next returns the time to wait before the next attempt. Half of the
+// exponential delay is fixed and the other half is random so that many
+// instances failing together do not retry in lockstep.
+func (b *backoff) next() time.Duration {
+	delay := b.initial << b.attempt
+	if delay > b.max || delay <= 0 {
+		delay = b.max
+	} else {
+		b.attempt++
+	}
+
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+
+	return time.Duration(half + rand.Int63n(half))
+}
+
+func isRetryable(err error) bool {
+	var serverErr *ServerError
+	var rateLimitErr *RateLimitError
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &serverErr), errors.As(err, &rateLimitErr):
+		return true
+	case errors.As(err, &netErr):
+		return true
+	default:
+		return false
+	}
+}
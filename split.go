@@ -0,0 +1,169 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// GroupPoints groups consecutive data points that were converted from the
+// same registry entry at the same time so that, for example, the fields of a
+// timer are never sent in different requests. The same entry from two
+// batches merged by the backlog ends up in two groups.
+func GroupPoints(points []*DataPoint) [][]*DataPoint {
+	var groups [][]*DataPoint
+	for i, point := range points {
+		if i > 0 && sameGroup(points[i-1], point) {
+			last := len(groups) - 1
+			groups[last] = append(groups[last], point)
+			continue
+		}
+
+		groups = append(groups, []*DataPoint{point})
+	}
+
+	return groups
+}
+
+func sameGroup(previous, point *DataPoint) bool {
+	return point.Metric != "" &&
+		point.Metric == previous.Metric &&
+		point.Timestamp == previous.Timestamp
+}
+
+// splitPoints splits points into chunks that stay within the configured
+// number of points and payload size. A single group that is over the limits
+// on its own is sent as its own chunk.
+func splitPoints(points []*DataPoint, options *Options) [][]*DataPoint {
+	if options.MaxPointsPerRequest <= 0 && options.MaxPayloadBytes <= 0 {
+		return [][]*DataPoint{points}
+	}
+
+	envelopeBytes := payloadSize(nil, options)
+
+	var chunks [][]*DataPoint
+	var chunk []*DataPoint
+	chunkBytes := envelopeBytes
+	for _, group := range GroupPoints(points) {
+		groupBytes := pointsSize(group)
+
+		overPoints := options.MaxPointsPerRequest > 0 && len(chunk)+len(group) > options.MaxPointsPerRequest
+		overBytes := options.MaxPayloadBytes > 0 && chunkBytes+groupBytes > options.MaxPayloadBytes
+		if len(chunk) > 0 && (overPoints || overBytes) {
+			chunks = append(chunks, chunk)
+			chunk = nil
+			chunkBytes = envelopeBytes
+		}
+
+		chunk = append(chunk, group...)
+		chunkBytes += groupBytes
+	}
+
+	if len(chunk) > 0 || len(chunks) == 0 {
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks
+}
+
+func payloadSize(points []*DataPoint, options *Options) int {
+	payload, err := json.Marshal(newMetricForwarderPayload(points, options))
+	if err != nil {
+		return 0
+	}
+
+	return len(payload)
+}
+
+// pointsSize is the number of bytes points add to a payload, including the
+// commas between them.
+func pointsSize(points []*DataPoint) int {
+	var size int
+	for _, point := range points {
+		encoded, err := json.Marshal(point)
+		if err != nil {
+			continue
+		}
+
+		size += len(encoded) + 1
+	}
+
+	return size
+}
+
+// chunkError reports which chunk of a split batch could not be sent.
+type chunkError struct {
+	index  int
+	total  int
+	points int
+	err    error
+}
+
+func (e *chunkError) Error() string {
+	return fmt.Sprintf("chunk %d of %d (%d points): %s", e.index, e.total, e.points, e.err.Error())
+}
+
+func (e *chunkError) Unwrap() error {
+	return e.err
+}
+
+type chunkErrors []*chunkError
+
+func (e chunkErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func (e chunkErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+
+	return errs
+}
+
+// unsentError is returned when only some of the points of a batch could not
+// be sent, so that the backlog keeps just those points.
+type unsentError struct {
+	points []*DataPoint
+	err    error
+}
+
+func (e *unsentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *unsentError) Unwrap() error {
+	return e.err
+}
+
+// unsentPoints returns the points of a batch that err says were not sent, or
+// the whole batch when it does not say.
+func unsentPoints(points []*DataPoint, err error) []*DataPoint {
+	var unsentErr *unsentError
+	if errors.As(err, &unsentErr) {
+		return unsentErr.points
+	}
+
+	return points
+}
@@ -0,0 +1,329 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSpoolMaxBytes = 10 * 1024 * 1024
+	spoolSegmentSuffix   = ".spool"
+	spoolRecordHeaderLen = 4
+)
+
+// diskSpool persists payloads that could not be delivered in a directory of
+// segment files. Each segment holds length-prefixed JSON payloads and is
+// named after the time it was created, so segments sort oldest first.
+type diskSpool struct {
+	dir             string
+	maxBytes        int64
+	maxSegmentBytes int64
+}
+
+func newDiskSpool(dir string, maxBytes int64) (*diskSpool, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultSpoolMaxBytes
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &diskSpool{
+		dir:             dir,
+		maxBytes:        maxBytes,
+		maxSegmentBytes: maxBytes / 8,
+	}
+
+	err = s.repair()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// repair removes records that were only partly written when a previous
+// process was killed, so that new records are not appended after them.
+func (s *diskSpool) repair() error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		records, err := readRecords(segment.path)
+		if err != nil {
+			return err
+		}
+
+		var size int64
+		for _, record := range records {
+			size += spoolRecordHeaderLen + int64(len(record))
+		}
+
+		if size < segment.size {
+			err = writeRecords(segment.path, records)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *diskSpool) push(payload *metricForwarderPayload) error {
+	record, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	path := s.newSegmentPath()
+	if len(segments) > 0 {
+		newest := segments[len(segments)-1]
+		if newest.size+int64(len(record)) <= s.maxSegmentBytes {
+			path = newest.path
+		}
+	}
+
+	err = appendRecord(path, record)
+	if err != nil {
+		return err
+	}
+
+	return s.evict()
+}
+
+// replay sends spooled payloads in the order they were spooled until the
+// deadline passes. It stops at the first payload that cannot be sent and
+// keeps it and everything after it for the next attempt.
+func (s *diskSpool) replay(deadline time.Time, send func(*metricForwarderPayload) error) error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		records, err := readRecords(segment.path)
+		if err != nil {
+			return err
+		}
+
+		for i, record := range records {
+			if time.Now().After(deadline) {
+				return writeRecords(segment.path, records[i:])
+			}
+
+			var payload metricForwarderPayload
+			err = json.Unmarshal(record, &payload)
+			if err != nil {
+				continue
+			}
+
+			err = send(&payload)
+			if err != nil {
+				rewriteErr := writeRecords(segment.path, records[i:])
+				if rewriteErr != nil {
+					return rewriteErr
+				}
+				return err
+			}
+		}
+
+		err = os.Remove(segment.path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// evict removes the oldest segments until the spool fits in maxBytes.
+func (s *diskSpool) evict() error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, segment := range segments {
+		total += segment.size
+	}
+
+	for _, segment := range segments {
+		if total <= s.maxBytes {
+			break
+		}
+
+		err = os.Remove(segment.path)
+		if err != nil {
+			return err
+		}
+		total -= segment.size
+	}
+
+	return nil
+}
+
+type spoolSegment struct {
+	path string
+	size int64
+}
+
+func (s *diskSpool) segments() ([]spoolSegment, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []spoolSegment
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), spoolSegmentSuffix) {
+			continue
+		}
+
+		segments = append(segments, spoolSegment{
+			path: filepath.Join(s.dir, info.Name()),
+			size: info.Size(),
+		})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].path < segments[j].path
+	})
+
+	return segments, nil
+}
+
+func (s *diskSpool) newSegmentPath() string {
+	name := fmt.Sprintf("%020d%s", time.Now().UnixNano(), spoolSegmentSuffix)
+	return filepath.Join(s.dir, name)
+}
+
+func appendRecord(path string, record []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = writeRecord(f, record)
+	if err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+func writeRecords(path string, records [][]byte) error {
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		err = writeRecord(f, record)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func writeRecord(w io.Writer, record []byte) error {
+	header := make([]byte, spoolRecordHeaderLen)
+	binary.BigEndian.PutUint32(header, uint32(len(record)))
+
+	_, err := w.Write(append(header, record...))
+	return err
+}
+
+// readRecords returns the complete records in a segment. A record that was
+// only partly written, for example because the process was killed, ends the
+// segment, as does a length that is longer than the rest of the segment.
+func readRecords(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	remaining := info.Size()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, spoolRecordHeaderLen)
+
+	var records [][]byte
+	for {
+		_, err = io.ReadFull(r, header)
+		if err != nil {
+			break
+		}
+		remaining -= spoolRecordHeaderLen
+
+		length := int64(binary.BigEndian.Uint32(header))
+		if length > remaining {
+			break
+		}
+
+		record := make([]byte, length)
+		_, err = io.ReadFull(r, record)
+		if err != nil {
+			break
+		}
+		remaining -= length
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,209 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pivotal-cf/go-metrics-pcf/internal/deltas"
+)
+
+const (
+	defaultStatsdNetwork        = "udp"
+	defaultStatsdMaxPacketBytes = 1432
+	defaultStatsdWriteTimeout   = 5 * time.Second
+)
+
+// StatsdOptions configures a StatsdTransporter.
+type StatsdOptions struct {
+	// Address is the host and port of the StatsD agent.
+	Address string
+
+	// Network is either "udp" or "tcp". It defaults to "udp".
+	Network string
+
+	// Prefix is prepended to every metric name.
+	Prefix string
+
+	// Tags are added to every line in the DogStatsD format. No tags are
+	// sent when it is empty, which plain StatsD agents require.
+	Tags map[string]string
+
+	// MaxPacketBytes is the largest datagram or write. It defaults to 1432,
+	// which fits in a 1500 byte Ethernet frame.
+	MaxPacketBytes int
+
+	// WriteTimeout defaults to 5 seconds.
+	WriteTimeout time.Duration
+}
+
+// StatsdTransporter sends data points to a StatsD or DogStatsD agent. Gauges
+// are sent as `name:value|g` and counters as `name:delta|c`, where delta is
+// the change since the last successful send.
+type StatsdTransporter struct {
+	options  StatsdOptions
+	conn     *reconnectingConn
+	counters *deltas.Counters
+	tags     string
+}
+
+// NewStatsdTransporter returns a transporter for StartExporterWithTransporter
+// that sends metrics to a StatsD agent. The connection is opened on the
+// first send.
+func NewStatsdTransporter(options StatsdOptions) *StatsdTransporter {
+	if options.Network == "" {
+		options.Network = defaultStatsdNetwork
+	}
+	if options.MaxPacketBytes == 0 {
+		options.MaxPacketBytes = defaultStatsdMaxPacketBytes
+	}
+	if options.WriteTimeout == 0 {
+		options.WriteTimeout = defaultStatsdWriteTimeout
+	}
+
+	return &StatsdTransporter{
+		options: options,
+		conn: &reconnectingConn{
+			network: options.Network,
+			address: options.Address,
+			timeout: options.WriteTimeout,
+		},
+		counters: deltas.New(),
+		tags:     formatStatsdTags(options.Tags),
+	}
+}
+
+func (t *StatsdTransporter) SendMetrics(points []*DataPoint) error {
+	var lines []string
+	// linePoints holds the data point each line sends, or nil for the line
+	// that resets a gauge before a negative value.
+	var linePoints []*DataPoint
+	counters := t.counters.Batch()
+	for _, point := range SortByTimestamp(points) {
+		if math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+			continue
+		}
+
+		name := statsdName(t.options.Prefix, point.Name)
+
+		if point.Type == "counter" {
+			delta, ok := counters.Delta(point.Name, point.Value, point.Timestamp)
+			if !ok {
+				continue
+			}
+			lines = append(lines, t.formatLine(name, delta, "c"))
+			linePoints = append(linePoints, point)
+			continue
+		}
+
+		if !counters.Current(point.Name, point.Timestamp) {
+			continue
+		}
+
+		// A gauge value with a sign is an adjustment to the current value,
+		// so negative values are sent after resetting the gauge to zero.
+		if point.Value < 0 {
+			lines = append(lines, t.formatLine(name, 0, "g"))
+			linePoints = append(linePoints, nil)
+		}
+		lines = append(lines, t.formatLine(name, point.Value, "g"))
+		linePoints = append(linePoints, point)
+	}
+
+	// The data points are recorded as sent packet by packet, so that a
+	// failed write does not send the deltas of the packets before it again.
+	for _, packet := range packLines(lines, t.options.MaxPacketBytes) {
+		_, err := t.conn.Write(packet)
+		if err != nil {
+			return err
+		}
+
+		packetLines := bytes.Count(packet, []byte{'\n'})
+		for _, point := range linePoints[:packetLines] {
+			if point != nil {
+				t.counters.Update(point.Name, point.Value, point.Timestamp)
+			}
+		}
+		linePoints = linePoints[packetLines:]
+	}
+
+	return nil
+}
+
+// Close closes the connection to the agent.
+func (t *StatsdTransporter) Close() error {
+	return t.conn.Close()
+}
+
+func (t *StatsdTransporter) formatLine(name string, value float64, statsdType string) string {
+	return name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + statsdType + t.tags
+}
+
+// statsdName replaces the characters that separate the fields of a StatsD
+// line with underscores.
+func statsdName(prefix, name string) string {
+	if prefix != "" {
+		name = joinNameParts(prefix, name)
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+func formatStatsdTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	var pairs []string
+	for key, value := range tags {
+		pairs = append(pairs, statsdName("", key)+":"+statsdName("", value))
+	}
+	sort.Strings(pairs)
+
+	return "|#" + strings.Join(pairs, ",")
+}
+
+// packLines joins newline terminated lines into packets of at most maxBytes.
+// A line that is longer than maxBytes is sent in a packet of its own.
+func packLines(lines []string, maxBytes int) [][]byte {
+	var packets [][]byte
+	var packet []byte
+
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+len(line)+1 > maxBytes {
+			packets = append(packets, packet)
+			packet = nil
+		}
+		packet = append(packet, line...)
+		packet = append(packet, '\n')
+	}
+
+	if len(packet) > 0 {
+		packets = append(packets, packet)
+	}
+
+	return packets
+}
@@ -0,0 +1,203 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+)
+
+var _ = Describe("StatsD transporter", func() {
+	var (
+		packetConn net.PacketConn
+		packets    chan string
+	)
+
+	BeforeEach(func() {
+		var err error
+		packetConn, err = net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		packets = make(chan string, 100)
+		go func() {
+			buf := make([]byte, 65536)
+			for {
+				n, _, err := packetConn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				packets <- string(buf[:n])
+			}
+		}()
+	})
+
+	AfterEach(func() {
+		packetConn.Close()
+	})
+
+	It("sends gauges and counter deltas", func() {
+		transport := pcfmetrics.NewStatsdTransporter(pcfmetrics.StatsdOptions{
+			Address: packetConn.LocalAddr().String(),
+			Prefix:  "app",
+		})
+		defer transport.Close()
+
+		err := transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-counter", Type: "counter", Value: 6},
+			{Name: "test.gauge", Type: "gauge", Value: 32.2},
+			{Name: "test.negative", Type: "gauge", Value: -3},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(packets).Should(Receive(Equal(
+			"app.test-counter:6|c\napp.test.gauge:32.2|g\napp.test.negative:0|g\napp.test.negative:-3|g\n",
+		)))
+
+		err = transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-counter", Type: "counter", Value: 10},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(packets).Should(Receive(Equal("app.test-counter:4|c\n")))
+	})
+
+	It("counts counter values in timestamp order", func() {
+		transport := pcfmetrics.NewStatsdTransporter(pcfmetrics.StatsdOptions{
+			Address: packetConn.LocalAddr().String(),
+		})
+		defer transport.Close()
+
+		err := transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-counter", Type: "counter", Value: 10, Timestamp: 2000},
+			{Name: "test-counter", Type: "counter", Value: 6, Timestamp: 1000},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(packets).Should(Receive(Equal("test-counter:6|c\ntest-counter:4|c\n")))
+
+		err = transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-counter", Type: "counter", Value: 8, Timestamp: 3000},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(packets).Should(Receive(Equal("test-counter:-2|c\n")))
+
+		err = transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test-counter", Type: "counter", Value: 10, Timestamp: 2000},
+			{Name: "test-counter", Type: "counter", Value: 9, Timestamp: 4000},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(packets).Should(Receive(Equal("test-counter:1|c\n")))
+	})
+
+	It("skips gauges older than the last one sent", func() {
+		transport := pcfmetrics.NewStatsdTransporter(pcfmetrics.StatsdOptions{
+			Address: packetConn.LocalAddr().String(),
+		})
+		defer transport.Close()
+
+		err := transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test.gauge", Type: "gauge", Value: 3, Timestamp: 2000},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(packets).Should(Receive(Equal("test.gauge:3|g\n")))
+
+		err = transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test.gauge", Type: "gauge", Value: 1, Timestamp: 1000},
+			{Name: "test.other", Type: "gauge", Value: 2, Timestamp: 1000},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(packets).Should(Receive(Equal("test.other:2|g\n")))
+	})
+
+	It("adds DogStatsD tags", func() {
+		transport := pcfmetrics.NewStatsdTransporter(pcfmetrics.StatsdOptions{
+			Address: packetConn.LocalAddr().String(),
+			Tags:    map[string]string{"instance_index": "1", "app_id": "some-guid"},
+		})
+		defer transport.Close()
+
+		err := transport.SendMetrics([]*pcfmetrics.DataPoint{
+			{Name: "test.gauge", Type: "gauge", Value: 1},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(packets).Should(Receive(Equal("test.gauge:1|g|#app_id:some-guid,instance_index:1\n")))
+	})
+
+	It("packs lines into datagrams of at most MaxPacketBytes", func() {
+		transport := pcfmetrics.NewStatsdTransporter(pcfmetrics.StatsdOptions{
+			Address:        packetConn.LocalAddr().String(),
+			MaxPacketBytes: 100,
+		})
+		defer transport.Close()
+
+		var points []*pcfmetrics.DataPoint
+		for i := 0; i < 30; i++ {
+			points = append(points, &pcfmetrics.DataPoint{Name: "test.gauge", Type: "gauge", Value: 1})
+		}
+
+		err := transport.SendMetrics(points)
+		Expect(err).ToNot(HaveOccurred())
+
+		lines := 0
+		for lines < 30 {
+			var packet string
+			Eventually(packets).Should(Receive(&packet))
+			Expect(len(packet)).To(BeNumerically("<=", 100))
+			lines += strings.Count(packet, "\n")
+		}
+		Expect(lines).To(Equal(30))
+	})
+
+	It("reconnects over TCP after the agent closes the connection", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer listener.Close()
+
+		lines := make(chan string, 10)
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				lines <- line
+				conn.Close()
+			}
+		}()
+
+		transport := pcfmetrics.NewStatsdTransporter(pcfmetrics.StatsdOptions{
+			Address: listener.Addr().String(),
+			Network: "tcp",
+		})
+		defer transport.Close()
+
+		points := []*pcfmetrics.DataPoint{{Name: "test.gauge", Type: "gauge", Value: 1}}
+
+		Expect(transport.SendMetrics(points)).To(Succeed())
+		Eventually(lines).Should(Receive(Equal("test.gauge:1|g\n")))
+
+		Eventually(func() error {
+			time.Sleep(10 * time.Millisecond)
+			return transport.SendMetrics(points)
+		}).Should(HaveOccurred())
+		Expect(transport.SendMetrics(points)).To(Succeed())
+		Eventually(lines).Should(Receive(Equal("test.gauge:1|g\n")))
+	})
+})
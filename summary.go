@@ -0,0 +1,98 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import "math"
+
+// Summary is a timer or histogram rebuilt from its data points, for formats
+// that have a native summary type.
+type Summary struct {
+	Name      string
+	Unit      string
+	Count     float64
+	Sum       float64
+	Quantiles []Quantile
+	Timestamp int64
+}
+
+// Quantile is the value of a percentile, such as the 99th, as a quantile,
+// such as 0.99.
+type Quantile struct {
+	Quantile float64
+	Value    float64
+}
+
+// Summarize finds the timer or histogram in a group of data points that
+// share the same Metric. It returns the summary, if there is one, and the
+// data points that are not part of it, such as the mean and the rates.
+func Summarize(group []*DataPoint) (*Summary, []*DataPoint) {
+	if len(group) == 0 {
+		return nil, group
+	}
+
+	byName := make(map[string]*DataPoint, len(group))
+	for _, point := range group {
+		byName[point.Name] = point
+	}
+
+	metric := group[0].Metric
+	for _, base := range []string{joinNameParts(metric, "duration"), metric} {
+		if _, ok := byName[getPercentileName(base, percentileIds[0])]; !ok {
+			continue
+		}
+
+		summary := &Summary{Name: base}
+		used := make(map[*DataPoint]bool)
+
+		for _, id := range percentileIds {
+			point, ok := byName[getPercentileName(base, id)]
+			if !ok {
+				continue
+			}
+
+			summary.Quantiles = append(summary.Quantiles, Quantile{Quantile: percentileQuantile(id), Value: point.Value})
+			summary.Unit = point.Unit
+			summary.Timestamp = point.Timestamp
+			used[point] = true
+		}
+
+		if count, ok := byName[joinNameParts(metric, "count")]; ok {
+			summary.Count = count.Value
+			used[count] = true
+		}
+
+		if sum, ok := byName[joinNameParts(base, "sum")]; ok {
+			summary.Sum = sum.Value
+			used[sum] = true
+		}
+
+		var rest []*DataPoint
+		for _, point := range group {
+			if !used[point] {
+				rest = append(rest, point)
+			}
+		}
+
+		return summary, rest
+	}
+
+	return nil, group
+}
+
+// percentileQuantile converts a percentile such as 99.9 to a quantile such
+// as 0.999 without the rounding error of dividing by 100.
+func percentileQuantile(percentile float64) float64 {
+	return math.Round(percentile*1000) / 100000
+}
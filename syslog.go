@@ -0,0 +1,217 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pivotal-cf/go-metrics-pcf/internal/deltas"
+)
+
+const (
+	defaultSyslogWriteTimeout = 5 * time.Second
+
+	// syslogPriority is facility user (1) and severity informational (6).
+	syslogPriority = "<14>"
+
+	// syslogEnterpriseId is the private enterprise number that Loggregator
+	// uses for the gauge and counter structured data elements.
+	syslogEnterpriseId = "47450"
+)
+
+// SyslogOptions configures a SyslogTransporter.
+type SyslogOptions struct {
+	// Address is the host and port of the syslog endpoint.
+	Address string
+
+	// TLS connects to the endpoint with TLS. RootCAFiles adds CAs to the
+	// system roots, and SkipSSLVerification disables verification of the
+	// endpoint's certificate.
+	TLS                 bool
+	RootCAFiles         []string
+	SkipSSLVerification bool
+
+	// Hostname defaults to the hostname of the container.
+	Hostname string
+
+	// WriteTimeout defaults to 5 seconds.
+	WriteTimeout time.Duration
+}
+
+// SyslogTransporter sends data points to a syslog endpoint as RFC 5424
+// messages with octet counting framing. Each data point is a message with
+// a gauge@47450 or counter@47450 structured data element, in the format
+// Loggregator uses for metrics in syslog drains.
+type SyslogTransporter struct {
+	options  SyslogOptions
+	conn     *reconnectingConn
+	appName  string
+	procId   string
+	counters *deltas.Counters
+}
+
+// NewSyslogTransporter returns a transporter for
+// StartExporterWithTransporter that sends metrics to a syslog endpoint. The
+// app GUID in opts is the APP-NAME and the instance index is the PROCID of
+// the messages, which default to the values in the environment as they do
+// for StartExporter.
+func NewSyslogTransporter(syslogOptions SyslogOptions, opts ...ExporterOption) (*SyslogTransporter, error) {
+	if syslogOptions.Hostname == "" {
+		syslogOptions.Hostname, _ = os.Hostname()
+	}
+	if syslogOptions.WriteTimeout == 0 {
+		syslogOptions.WriteTimeout = defaultSyslogWriteTimeout
+	}
+
+	conn := &reconnectingConn{
+		network: "tcp",
+		address: syslogOptions.Address,
+		timeout: syslogOptions.WriteTimeout,
+	}
+
+	if syslogOptions.TLS {
+		tlsConfig, err := createTLSConfig(&Options{
+			RootCAFiles:         syslogOptions.RootCAFiles,
+			SkipSSLVerification: syslogOptions.SkipSSLVerification,
+		})
+		if err != nil {
+			return nil, err
+		}
+		conn.tlsConfig = tlsConfig
+	}
+
+	options := InstanceOptions(opts...)
+
+	return &SyslogTransporter{
+		options:  syslogOptions,
+		conn:     conn,
+		appName:  options.AppGuid,
+		procId:   options.InstanceIndex,
+		counters: deltas.New(),
+	}, nil
+}
+
+// SendMetrics sends the messages of a batch with a single write. A failed
+// write closes the connection, which is opened again for the next batch.
+func (t *SyslogTransporter) SendMetrics(points []*DataPoint) error {
+	var buf bytes.Buffer
+	counters := t.counters.Batch()
+	for _, point := range SortByTimestamp(points) {
+		if math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+			continue
+		}
+
+		var delta float64
+		if point.Type == "counter" {
+			var ok bool
+			delta, ok = counters.Delta(point.Name, point.Value, point.Timestamp)
+			if !ok {
+				continue
+			}
+		}
+
+		message := t.formatMessage(point, delta)
+		buf.WriteString(strconv.Itoa(len(message)))
+		buf.WriteByte(' ')
+		buf.WriteString(message)
+	}
+
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	_, err := t.conn.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	counters.Commit()
+
+	return nil
+}
+
+// Close closes the connection to the syslog endpoint.
+func (t *SyslogTransporter) Close() error {
+	return t.conn.Close()
+}
+
+func (t *SyslogTransporter) formatMessage(point *DataPoint, delta float64) string {
+	var data string
+	if point.Type == "counter" {
+		data = formatStructuredData("counter",
+			"name", point.Name,
+			"total", strconv.FormatFloat(point.Value, 'f', -1, 64),
+			"delta", strconv.FormatFloat(delta, 'f', -1, 64),
+		)
+	} else {
+		data = formatStructuredData("gauge",
+			"name", point.Name,
+			"value", strconv.FormatFloat(point.Value, 'f', -1, 64),
+			"unit", point.Unit,
+		)
+	}
+
+	timestamp := time.Unix(0, point.Timestamp*int64(time.Millisecond)).UTC()
+
+	return strings.Join([]string{
+		syslogPriority + "1",
+		timestamp.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(t.options.Hostname, 255),
+		syslogHeaderField(t.appName, 48),
+		syslogHeaderField(t.procId, 128),
+		"-",
+		data,
+	}, " ")
+}
+
+// formatStructuredData formats an SD-ELEMENT with the enterprise ID and the
+// name and value pairs as SD-PARAMs.
+func formatStructuredData(id string, params ...string) string {
+	var element strings.Builder
+	element.WriteString("[" + id + "@" + syslogEnterpriseId)
+	for i := 0; i+1 < len(params); i += 2 {
+		element.WriteString(" " + params[i] + `="` + syslogParamEscaper.Replace(params[i+1]) + `"`)
+	}
+	element.WriteString("]")
+
+	return element.String()
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogHeaderField returns "-" for empty header fields, and otherwise the
+// value without the characters that header fields cannot contain.
+func syslogHeaderField(value string, maxLength int) string {
+	value = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, value)
+
+	if len(value) > maxLength {
+		value = value[:maxLength]
+	}
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
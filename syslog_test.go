@@ -0,0 +1,141 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+)
+
+var _ = Describe("Syslog transporter", func() {
+	var (
+		listener net.Listener
+		messages chan string
+		points   []*pcfmetrics.DataPoint
+	)
+
+	var readFrames = func(conn net.Conn) {
+		defer GinkgoRecover()
+
+		reader := bufio.NewReader(conn)
+		for {
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+			Expect(err).ToNot(HaveOccurred())
+
+			message := make([]byte, n)
+			_, err = io.ReadFull(reader, message)
+			if err != nil {
+				return
+			}
+			messages <- string(message)
+		}
+	}
+
+	var serve = func() {
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go readFrames(conn)
+			}
+		}()
+	}
+
+	BeforeEach(func() {
+		messages = make(chan string, 100)
+		points = []*pcfmetrics.DataPoint{
+			{Name: "test-timer.duration.mean", Type: "gauge", Unit: "milliseconds", Value: 5.5, Timestamp: 1500000000123},
+			{Name: `test"counter]`, Type: "counter", Value: 6, Timestamp: 1500000000123},
+		}
+	})
+
+	AfterEach(func() {
+		listener.Close()
+	})
+
+	It("sends octet-counted RFC 5424 messages with structured data", func() {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		serve()
+
+		transport, err := pcfmetrics.NewSyslogTransporter(
+			pcfmetrics.SyslogOptions{Address: listener.Addr().String(), Hostname: "some-host"},
+			pcfmetrics.WithAppGuid("some-app-guid"),
+			pcfmetrics.WithInstanceIndex("1"),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		defer transport.Close()
+
+		Expect(transport.SendMetrics(points)).To(Succeed())
+
+		Eventually(messages).Should(Receive(Equal(
+			`<14>1 2017-07-14T02:40:00.123000Z some-host some-app-guid 1 - ` +
+				`[gauge@47450 name="test-timer.duration.mean" value="5.5" unit="milliseconds"]`,
+		)))
+		Eventually(messages).Should(Receive(Equal(
+			`<14>1 2017-07-14T02:40:00.123000Z some-host some-app-guid 1 - ` +
+				`[counter@47450 name="test\"counter\]" total="6" delta="6"]`,
+		)))
+
+		points[1].Value = 10
+		Expect(transport.SendMetrics(points[1:])).To(Succeed())
+		Eventually(messages).Should(Receive(HaveSuffix(`total="10" delta="4"]`)))
+	})
+
+	It("sends messages over TLS", func() {
+		certPEM, keyPEM := generateServerCertificate()
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		Expect(err).ToNot(HaveOccurred())
+
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+		Expect(err).ToNot(HaveOccurred())
+		serve()
+
+		dir, err := ioutil.TempDir("", "syslog")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		Expect(ioutil.WriteFile(filepath.Join(dir, "ca.pem"), certPEM, 0600)).To(Succeed())
+
+		transport, err := pcfmetrics.NewSyslogTransporter(pcfmetrics.SyslogOptions{
+			Address:     listener.Addr().String(),
+			TLS:         true,
+			RootCAFiles: []string{filepath.Join(dir, "ca.pem")},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer transport.Close()
+
+		Expect(transport.SendMetrics(points[:1])).To(Succeed())
+		Eventually(messages).Should(Receive(ContainSubstring(`[gauge@47450 name="test-timer.duration.mean"`)))
+	})
+})
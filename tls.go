@@ -0,0 +1,128 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcfmetrics
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func createTLSConfig(options *Options) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: options.SkipSSLVerification,
+		MinVersion:         options.MinTLSVersion,
+	}
+
+	rootCAs, err := loadRootCAs(options)
+	if err != nil {
+		return nil, err
+	}
+	config.RootCAs = rootCAs
+
+	cert, err := loadClientCertificate(options)
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+
+	return config, nil
+}
+
+// loadRootCAs returns the system roots plus any extra CAs from the options,
+// or nil to use the system roots when there are no extra CAs.
+func loadRootCAs(options *Options) (*x509.CertPool, error) {
+	if len(options.RootCAFiles) == 0 && len(options.RootCAPEM) == 0 && !options.UseCFSystemCerts {
+		return nil, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	files := options.RootCAFiles
+	if options.UseCFSystemCerts {
+		systemCerts, err := cfSystemCertFiles()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, systemCerts...)
+	}
+
+	for _, file := range files {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", file)
+		}
+	}
+
+	if len(options.RootCAPEM) > 0 && !pool.AppendCertsFromPEM(options.RootCAPEM) {
+		return nil, fmt.Errorf("no certificates found in root CA PEM")
+	}
+
+	return pool, nil
+}
+
+// cfSystemCertFiles lists the certificates in the directory named by
+// CF_SYSTEM_CERT_PATH.
+func cfSystemCertFiles() ([]string, error) {
+	dir := os.Getenv("CF_SYSTEM_CERT_PATH")
+	if dir == "" {
+		return nil, fmt.Errorf("CF_SYSTEM_CERT_PATH is not set")
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			files = append(files, filepath.Join(dir, info.Name()))
+		}
+	}
+
+	return files, nil
+}
+
+func loadClientCertificate(options *Options) (*tls.Certificate, error) {
+	switch {
+	case options.ClientCertFile != "" || options.ClientKeyFile != "":
+		cert, err := tls.LoadX509KeyPair(options.ClientCertFile, options.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	case len(options.ClientCertPEM) > 0 || len(options.ClientKeyPEM) > 0:
+		cert, err := tls.X509KeyPair(options.ClientCertPEM, options.ClientKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	default:
+		return nil, nil
+	}
+}
@@ -16,18 +16,31 @@ package pcfmetrics
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"time"
 )
 
+const maxDrainBytes = 4 * 1024 * 1024
+
+// HttpClient sends requests to the metrics forwarder. *http.Client
+// implements it.
 type HttpClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
 type httpTransporter struct {
-	client  HttpClient
-	options *Options
+	client        HttpClient
+	options       *Options
+	spool         *diskSpool
+	pausedUntil   time.Time
+	deferred      []*DataPoint
+	deferredIndex map[string]int
 }
 
 func newHttpTransporter(client HttpClient, options *Options) *httpTransporter {
@@ -37,26 +50,246 @@ func newHttpTransporter(client HttpClient, options *Options) *httpTransporter {
 	}
 }
 
-func (h *httpTransporter) sendMetrics(points []*dataPoint) error {
-	req, err := h.createRequest(points)
+func (h *httpTransporter) SendMetrics(points []*DataPoint) error {
+	if h.paused() {
+		h.deferPoints(points)
+		return nil
+	}
+
+	var wasDeferred map[*DataPoint]bool
+	if len(h.deferred) > 0 {
+		wasDeferred = make(map[*DataPoint]bool, len(h.deferred))
+		for _, point := range h.deferred {
+			wasDeferred[point] = true
+		}
+
+		h.deferPoints(points)
+		points = h.takeDeferred()
+	}
+
+	// All the requests of a batch, including the halves of a batch that was
+	// too large and the replayed spool, share one retry deadline.
+	deadline := time.Now().Add(h.options.RetryDeadline)
+
+	chunks := splitPoints(points, h.options)
+	if len(chunks) == 1 {
+		unsent, err := h.sendChunk(chunks[0], deadline)
+		if err != nil {
+			return &unsentError{points: h.keepDeferred(unsent, wasDeferred), err: err}
+		}
+
+		h.replaySpool(deadline)
+		return nil
+	}
+
+	var unsent []*DataPoint
+	var errs chunkErrors
+	for i, chunk := range chunks {
+		if h.paused() {
+			h.deferPoints(chunk)
+			continue
+		}
+
+		chunkUnsent, err := h.sendChunk(chunk, deadline)
+		if err != nil {
+			unsent = append(unsent, chunkUnsent...)
+			errs = append(errs, &chunkError{
+				index:  i + 1,
+				total:  len(chunks),
+				points: len(chunk),
+				err:    err,
+			})
+		}
+	}
+
+	if len(errs) > 0 {
+		return &unsentError{points: h.keepDeferred(unsent, wasDeferred), err: errs}
+	}
+
+	h.replaySpool(deadline)
+	return nil
+}
+
+// sendChunk sends points in one request, retrying until the deadline, and
+// returns the points that could not be sent.
+func (h *httpTransporter) sendChunk(points []*DataPoint, deadline time.Time) ([]*DataPoint, error) {
+	payload := newMetricForwarderPayload(points, h.options)
+
+	err := h.sendPayloadBefore(payload, deadline)
+	if err == nil {
+		return nil, nil
+	}
+
+	var tooLargeErr *PayloadTooLargeError
+	if errors.As(err, &tooLargeErr) {
+		groups := GroupPoints(points)
+		if len(groups) > 1 {
+			return h.sendHalves(groups, deadline)
+		}
+
+		return points, err
+	}
+
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		h.pause(rateLimitErr.RetryAfter)
+		h.deferPoints(points)
+		return nil, nil
+	}
+
+	h.spoolPayload(payload)
+	return points, err
+}
+
+// sendHalves sends groups that the forwarder rejected as too large in two
+// smaller requests, which are split again if they are still too large.
+func (h *httpTransporter) sendHalves(groups [][]*DataPoint, deadline time.Time) ([]*DataPoint, error) {
+	var first, second []*DataPoint
+	for i, group := range groups {
+		if i < len(groups)/2 {
+			first = append(first, group...)
+		} else {
+			second = append(second, group...)
+		}
+	}
+
+	firstUnsent, firstErr := h.sendChunk(first, deadline)
+	secondUnsent, secondErr := h.sendChunk(second, deadline)
+	unsent := append(append([]*DataPoint{}, firstUnsent...), secondUnsent...)
+	if firstErr != nil {
+		return unsent, firstErr
+	}
+
+	return unsent, secondErr
+}
+
+// spoolPoints spools points without trying to send them, for batches the
+// circuit breaker rejects while it is open.
+func (h *httpTransporter) spoolPoints(points []*DataPoint) {
+	if h.spool == nil {
+		return
+	}
+
+	for _, chunk := range splitPoints(points, h.options) {
+		h.spoolPayload(newMetricForwarderPayload(chunk, h.options))
+	}
+}
+
+func (h *httpTransporter) spoolPayload(payload *metricForwarderPayload) {
+	if h.spool == nil {
+		return
+	}
+
+	err := h.spool.push(payload)
+	if err != nil {
+		log.Printf("Could not spool metrics: %s", err.Error())
+	}
+}
+
+// replaySpool sends spooled payloads until the deadline of the batch that
+// was just sent, so that a large spool is replayed over several intervals
+// instead of delaying the next one.
+func (h *httpTransporter) replaySpool(deadline time.Time) {
+	if h.spool == nil || h.paused() || time.Now().After(deadline) {
+		return
+	}
+
+	err := h.spool.replay(deadline, func(payload *metricForwarderPayload) error {
+		return h.sendSpooledPayload(payload, deadline)
+	})
+	if err != nil {
+		log.Printf("Could not replay spooled metrics: %s", err.Error())
+	}
+}
+
+// sendSpooledPayload drops spooled payloads that the forwarder rejects for
+// good, such as a payload that is too large or malformed, since they would
+// otherwise block the spool forever.
+func (h *httpTransporter) sendSpooledPayload(payload *metricForwarderPayload, deadline time.Time) error {
+	err := h.sendPayloadBefore(payload, deadline)
+	if err != nil && !isRetryable(err) {
+		log.Printf("Dropping spooled metrics: %s", err.Error())
+		return nil
+	}
+
+	return err
+}
+
+// sendPayloadBefore sends payload, retrying failures that may be temporary
+// until the deadline.
+func (h *httpTransporter) sendPayloadBefore(payload *metricForwarderPayload, deadline time.Time) error {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	b := newBackoff(h.options.RetryInitialBackoff, h.options.RetryMaxBackoff)
+	for attempt := 1; ; attempt++ {
+		err := h.send(ctx, payload)
+		if err == nil || attempt >= h.options.RetryMaxAttempts || !isRetryable(err) {
+			return err
+		}
+
+		wait := b.next()
+
+		var rateLimitErr *RateLimitError
+		if errors.As(err, &rateLimitErr) && rateLimitErr.RetryAfter > wait {
+			wait = rateLimitErr.RetryAfter
+		}
+
+		if time.Now().Add(wait).After(deadline) {
+			return err
+		}
+
+		time.Sleep(wait)
+	}
+}
+
+func (h *httpTransporter) send(ctx context.Context, payload *metricForwarderPayload) error {
+	req, err := h.createRequest(payload)
 	if err != nil {
 		return err
 	}
 
+	req = req.WithContext(ctx)
+	if h.options.RequestHook != nil {
+		err = h.options.RequestHook(req)
+		if err != nil {
+			return err
+		}
+	}
+
 	res, err := h.client.Do(req)
 	if err != nil {
 		return err
 	}
+	defer drainAndClose(res.Body)
 
 	if res.StatusCode < 200 || res.StatusCode > 299 {
-		return fmt.Errorf("Received a non-2xx status code: %d", res.StatusCode)
+		return NewForwarderError(res)
+	}
+
+	if rateLimitExhausted(res.Header) {
+		h.pause(rateLimitReset(res.Header, time.Now()))
 	}
 
 	return nil
 }
 
-func (h *httpTransporter) createRequest(points []*dataPoint) (req *http.Request, err error) {
-	body, err := h.createBytesBufferPayload(points)
+func (h *httpTransporter) createRequest(payload *metricForwarderPayload) (req *http.Request, err error) {
+	body, err := h.createBytesBufferPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := h.options.Compression
+	if encoding != "" && body.Len() >= h.options.CompressionThreshold {
+		compressed, err := Compress(encoding, body.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewBuffer(compressed)
+	} else {
+		encoding = ""
+	}
 
 	req, err = http.NewRequest(http.MethodPost, h.options.Url, body)
 	if err != nil {
@@ -65,17 +298,25 @@ func (h *httpTransporter) createRequest(points []*dataPoint) (req *http.Request,
 
 	req.Header.Add("Authorization", h.options.Token)
 	req.Header.Add("Content-Type", "application/json")
+	if encoding != "" {
+		req.Header.Add("Content-Encoding", encoding)
+	}
 
 	return req, err
 }
 
-func (h *httpTransporter) createBytesBufferPayload(points []*dataPoint) (body *bytes.Buffer, err error) {
-	payload := newMetricForwarderPayload(points, h.options)
-
-	jsonPayload, err := json.Marshal(&payload)
+func (h *httpTransporter) createBytesBufferPayload(payload *metricForwarderPayload) (body *bytes.Buffer, err error) {
+	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
 		return nil, err
 	}
 
 	return bytes.NewBuffer(jsonPayload), nil
 }
+
+// drainAndClose reads what is left of a response body so that the connection
+// can be reused for the next request.
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, io.LimitReader(body, maxDrainBytes))
+	body.Close()
+}
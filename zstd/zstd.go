@@ -0,0 +1,52 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package zstd registers zstd compression with pcfmetrics. Import it for its
+// side effect to use pcfmetrics.CompressionZstd:
+//
+//	import _ "github.com/pivotal-cf/go-metrics-pcf/zstd"
+package zstd
+
+import (
+	"bytes"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+)
+
+func init() {
+	pcfmetrics.RegisterCompression(pcfmetrics.CompressionZstd, compress)
+}
+
+func compress(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := zstd.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
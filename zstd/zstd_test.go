@@ -0,0 +1,72 @@
+// Copyright (C) 2017-Present Pivotal Software, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zstd_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pivotal-cf/go-metrics-pcf"
+	_ "github.com/pivotal-cf/go-metrics-pcf/zstd"
+	"github.com/rcrowley/go-metrics"
+)
+
+var _ = Describe("zstd compression", func() {
+	It("compresses request bodies once the package is imported", func() {
+		requests := make(chan *http.Request, 100)
+		bodies := make(chan []byte, 100)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			requests <- req
+			bodies <- body
+		}))
+		defer server.Close()
+
+		registry := metrics.NewRegistry()
+		counter := metrics.NewCounter()
+		counter.Inc(6)
+		registry.Register("test-counter", counter)
+
+		stop := pcfmetrics.StartExporter(
+			registry,
+			pcfmetrics.WithFrequency(100*time.Millisecond),
+			pcfmetrics.WithToken("fake-token"),
+			pcfmetrics.WithURL(server.URL),
+			pcfmetrics.WithAppGuid("fake-app-guid"),
+			pcfmetrics.WithCompression(pcfmetrics.CompressionZstd, 1),
+		)
+		defer stop()
+
+		var req *http.Request
+		Eventually(requests).Should(Receive(&req))
+		Expect(req.Header.Get("Content-Encoding")).To(Equal("zstd"))
+
+		var body []byte
+		Eventually(bodies).Should(Receive(&body))
+		r, err := zstd.NewReader(bytes.NewReader(body))
+		Expect(err).ToNot(HaveOccurred())
+		defer r.Close()
+		payload, err := ioutil.ReadAll(r)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(payload)).To(ContainSubstring(`"name":"test-counter"`))
+	})
+})